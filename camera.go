@@ -0,0 +1,237 @@
+package main
+
+import (
+	"math"
+	"time"
+
+	"fyne.io/fyne/v2"
+)
+
+const flyToDuration = 1200 * time.Millisecond
+
+type LatLngBounds struct {
+	SouthWest LatLng
+	NorthEast LatLng
+}
+
+// pendingFit holds a FitBounds request made before the widget had a size.
+type pendingFit struct {
+	bounds  LatLngBounds
+	padding float32
+}
+
+// SetZoomRange limits the zoom levels the map may show, by default minZoom..maxZoom.
+func (m *TileMapWidget) SetZoomRange(min, max int) {
+	if max < min {
+		min, max = max, min
+	}
+	m.mu.Lock()
+	m.zoomMin, m.zoomMax = min, max
+	m.zoom = m.clampZoomLocked(m.zoom)
+	m.mu.Unlock()
+	m.Refresh()
+}
+
+func (m *TileMapWidget) ZoomRange() (int, int) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.zoomMin, m.zoomMax
+}
+
+func (m *TileMapWidget) clampZoomLocked(zoom int) int {
+	if zoom < m.zoomMin {
+		return m.zoomMin
+	}
+	if zoom > m.zoomMax {
+		return m.zoomMax
+	}
+	return zoom
+}
+
+// SetView moves the map to center at the given zoom, clamped to the zoom range.
+func (m *TileMapWidget) SetView(center LatLng, zoom int) {
+	m.stopFlight()
+	m.mu.Lock()
+	m.centerLat = center.Lat
+	m.centerLon = center.Lon
+	m.zoom = m.clampZoomLocked(zoom)
+	m.pendingFit = nil
+	m.mu.Unlock()
+	m.clampView()
+	m.Refresh()
+}
+
+func (m *TileMapWidget) View() (LatLng, int) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return LatLng{Lat: m.centerLat, Lon: m.centerLon}, m.zoom
+}
+
+// FitBounds frames bounds with padding pixels on every side, using the deepest
+// zoom that still fits. If the widget has not been laid out yet the fit is
+// applied on the first layout.
+func (m *TileMapWidget) FitBounds(bounds LatLngBounds, padding float32) {
+	m.stopFlight()
+	m.mu.Lock()
+	w, h := m.width, m.height
+	if w <= 0 || h <= 0 {
+		m.pendingFit = &pendingFit{bounds: bounds, padding: padding}
+		m.mu.Unlock()
+		return
+	}
+	m.pendingFit = nil
+	zoomMin, zoomMax := m.zoomMin, m.zoomMax
+	m.mu.Unlock()
+
+	center, zoom := fitBoundsView(bounds, padding, w, h, zoomMin, zoomMax)
+	m.SetView(center, zoom)
+}
+
+// FitMarkers frames all markers on the map. A single marker is centered at the current zoom.
+func (m *TileMapWidget) FitMarkers() {
+	m.mu.RLock()
+	markers := make([]*MapMarker, len(m.markers))
+	copy(markers, m.markers)
+	zoom := m.zoom
+	m.mu.RUnlock()
+
+	switch len(markers) {
+	case 0:
+		return
+	case 1:
+		m.SetView(LatLng{Lat: markers[0].Lat, Lon: markers[0].Lon}, zoom)
+		return
+	}
+
+	points := make([]LatLng, len(markers))
+	for i, marker := range markers {
+		points[i] = LatLng{Lat: marker.Lat, Lon: marker.Lon}
+	}
+	m.FitBounds(latLngBounds(points...), markerHitRadius*2)
+}
+
+// latLngBounds returns the smallest bounds containing all points.
+func latLngBounds(points ...LatLng) LatLngBounds {
+	if len(points) == 0 {
+		return LatLngBounds{}
+	}
+	bounds := LatLngBounds{SouthWest: points[0], NorthEast: points[0]}
+	for _, p := range points[1:] {
+		bounds.SouthWest.Lat = math.Min(bounds.SouthWest.Lat, p.Lat)
+		bounds.SouthWest.Lon = math.Min(bounds.SouthWest.Lon, p.Lon)
+		bounds.NorthEast.Lat = math.Max(bounds.NorthEast.Lat, p.Lat)
+		bounds.NorthEast.Lon = math.Max(bounds.NorthEast.Lon, p.Lon)
+	}
+	return bounds
+}
+
+// FlyTo animates the map center to center and switches to zoom on arrival.
+// onDone, if not nil, is called once the flight has finished, or when it is
+// cut short by another camera change. A flight without onDone that replaces
+// a running one takes over the running flight's onDone.
+func (m *TileMapWidget) FlyTo(center LatLng, zoom int, onDone func()) {
+	if previous := m.cancelFlight(); onDone == nil {
+		onDone = previous
+	}
+	m.mu.RLock()
+	startZoom := m.zoom
+	startX, startY := latLonToTileXY(m.centerLat, m.centerLon, startZoom)
+	m.mu.RUnlock()
+
+	endX, endY := latLonToTileXY(center.Lat, center.Lon, startZoom)
+	n := math.Pow(2.0, float64(startZoom))
+	// Take the short way around the antimeridian.
+	if endX-startX > n/2 {
+		endX -= n
+	} else if startX-endX > n/2 {
+		endX += n
+	}
+
+	var flight *fyne.Animation
+	flight = fyne.NewAnimation(flyToDuration, func(progress float32) {
+		p := float64(progress)
+		lat, lon := tileXYToLatLon(startX+(endX-startX)*p, startY+(endY-startY)*p, startZoom)
+		m.mu.Lock()
+		if m.flight != flight {
+			m.mu.Unlock()
+			return
+		}
+		m.centerLat, m.centerLon = lat, lon
+		finished := progress >= 1
+		if finished {
+			m.centerLat, m.centerLon = center.Lat, center.Lon
+			m.zoom = m.clampZoomLocked(zoom)
+			m.flight, m.flightDone = nil, nil
+		}
+		m.mu.Unlock()
+		m.clampView()
+		m.Refresh()
+		if finished && onDone != nil {
+			onDone()
+		}
+	})
+	flight.Curve = fyne.AnimationEaseInOut
+
+	m.mu.Lock()
+	m.flight, m.flightDone = flight, onDone
+	m.pendingFit = nil
+	m.mu.Unlock()
+	flight.Start()
+}
+
+// stopFlight ends a running FlyTo where it is, still calling its onDone.
+func (m *TileMapWidget) stopFlight() {
+	if done := m.cancelFlight(); done != nil {
+		done()
+	}
+}
+
+// cancelFlight stops a running FlyTo and returns its onDone without calling it.
+func (m *TileMapWidget) cancelFlight() func() {
+	m.mu.Lock()
+	flight, done := m.flight, m.flightDone
+	m.flight, m.flightDone = nil, nil
+	m.mu.Unlock()
+	if flight != nil {
+		flight.Stop()
+	}
+	return done
+}
+
+func (m *TileMapWidget) applyPendingFit() {
+	m.mu.RLock()
+	fit := m.pendingFit
+	w, h := m.width, m.height
+	m.mu.RUnlock()
+	if fit == nil || w <= 0 || h <= 0 {
+		return
+	}
+	m.FitBounds(fit.bounds, fit.padding)
+}
+
+// fitBoundsView returns the center and deepest zoom in zoomMin..zoomMax at
+// which bounds fit inside a w x h viewport with padding on every side.
+func fitBoundsView(bounds LatLngBounds, padding, w, h float32, zoomMin, zoomMax int) (LatLng, int) {
+	west, east := bounds.SouthWest.Lon, bounds.NorthEast.Lon
+	if west > east {
+		// Bounds crossing the antimeridian.
+		east += 360
+	}
+	availW := math.Max(float64(w-2*padding), 1)
+	availH := math.Max(float64(h-2*padding), 1)
+
+	zoom := zoomMin
+	for z := zoomMax; z >= zoomMin; z-- {
+		x0, y0 := latLonToTileXY(bounds.NorthEast.Lat, west, z)
+		x1, y1 := latLonToTileXY(bounds.SouthWest.Lat, east, z)
+		if (x1-x0)*mapTileSize <= availW && (y1-y0)*mapTileSize <= availH {
+			zoom = z
+			break
+		}
+	}
+
+	x0, y0 := latLonToTileXY(bounds.NorthEast.Lat, west, zoom)
+	x1, y1 := latLonToTileXY(bounds.SouthWest.Lat, east, zoom)
+	lat, lon := tileXYToLatLon((x0+x1)/2, (y0+y1)/2, zoom)
+	return LatLng{Lat: lat, Lon: lon}, zoom
+}
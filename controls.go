@@ -0,0 +1,111 @@
+package main
+
+import (
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/theme"
+	"fyne.io/fyne/v2/widget"
+)
+
+const controlsLayerID = "controls"
+
+// SetControlsVisible shows or hides the on-map zoom, reset and locate buttons.
+func (m *TileMapWidget) SetControlsVisible(visible bool) {
+	m.SetLayerVisible(controlsLayerID, visible)
+}
+
+// SetLocateTarget sets the marker the locate button flies to; an empty ID hides the button.
+func (m *TileMapWidget) SetLocateTarget(id string) {
+	m.mu.Lock()
+	m.locateID = id
+	m.mu.Unlock()
+	m.Refresh()
+}
+
+// ResetView flies back to the view the map had when it was first shown.
+func (m *TileMapWidget) ResetView() {
+	m.mu.RLock()
+	home := m.home
+	m.mu.RUnlock()
+	if home == nil {
+		return
+	}
+	m.FlyTo(LatLng{Lat: home.centerLat, Lon: home.centerLon}, home.zoom, nil)
+}
+
+// LocateTarget flies to the marker set with SetLocateTarget.
+func (m *TileMapWidget) LocateTarget() {
+	m.mu.RLock()
+	id := m.locateID
+	m.mu.RUnlock()
+	if marker := m.Marker(id); marker != nil {
+		_, zoom := m.View()
+		m.FlyTo(LatLng{Lat: marker.Lat, Lon: marker.Lon}, zoom, nil)
+	}
+}
+
+// recordHomeView remembers the first laid-out view for ResetView.
+func (m *TileMapWidget) recordHomeView() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.home != nil || m.width <= 0 || m.height <= 0 || m.pendingFit != nil {
+		return
+	}
+	m.home = &mapView{zoom: m.zoom, centerLat: m.centerLat, centerLon: m.centerLon}
+}
+
+// mapControlsOverlay anchors the zoom, reset and locate buttons to the top-right corner.
+type mapControlsOverlay struct {
+	mapWidget *TileMapWidget
+	zoomIn    *widget.Button
+	zoomOut   *widget.Button
+	reset     *widget.Button
+	locate    *widget.Button
+	box       *fyne.Container
+}
+
+func newMapControlsOverlay() *mapControlsOverlay {
+	return &mapControlsOverlay{}
+}
+
+func (o *mapControlsOverlay) LayerID() string { return controlsLayerID }
+
+func (o *mapControlsOverlay) attach(m *TileMapWidget) {
+	o.mapWidget = m
+	o.zoomIn = widget.NewButtonWithIcon("", theme.ZoomInIcon(), m.ZoomIn)
+	o.zoomOut = widget.NewButtonWithIcon("", theme.ZoomOutIcon(), m.ZoomOut)
+	o.reset = widget.NewButtonWithIcon("", theme.HomeIcon(), m.ResetView)
+	o.locate = widget.NewButtonWithIcon("", theme.RadioButtonCheckedIcon(), m.LocateTarget)
+	o.box = container.NewVBox(o.zoomIn, o.zoomOut, o.reset, o.locate)
+	m.mu.Lock()
+	m.hiddenLayers[controlsLayerID] = true
+	m.mu.Unlock()
+}
+
+func (o *mapControlsOverlay) refresh(view mapView) []fyne.CanvasObject {
+	o.mapWidget.mu.RLock()
+	hasTarget := o.mapWidget.markerIndex(o.mapWidget.locateID) >= 0
+	zoomMin, zoomMax := o.mapWidget.zoomMin, o.mapWidget.zoomMax
+	o.mapWidget.mu.RUnlock()
+
+	setEnabled(o.zoomIn, view.zoom < zoomMax)
+	setEnabled(o.zoomOut, view.zoom > zoomMin)
+	if hasTarget {
+		o.locate.Show()
+	} else {
+		o.locate.Hide()
+	}
+
+	size := o.box.MinSize()
+	o.box.Resize(size)
+	o.box.Move(fyne.NewPos(view.width-size.Width-theme.Padding(), theme.Padding()))
+	return []fyne.CanvasObject{o.box}
+}
+
+func setEnabled(button *widget.Button, enabled bool) {
+	if enabled && button.Disabled() {
+		button.Enable()
+	} else if !enabled && !button.Disabled() {
+		button.Disable()
+	}
+}
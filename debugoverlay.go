@@ -0,0 +1,213 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"image/color"
+	"sync"
+	"time"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/canvas"
+	"fyne.io/fyne/v2/theme"
+)
+
+const debugLayerID = "debug"
+
+var (
+	debugMemoryColor   = color.NRGBA{R: 80, G: 220, B: 120, A: 255}
+	debugDiskColor     = color.NRGBA{R: 80, G: 200, B: 255, A: 255}
+	debugFetchingColor = color.NRGBA{R: 255, G: 210, B: 0, A: 255}
+	debugFailedColor   = color.NRGBA{R: 255, G: 70, B: 70, A: 255}
+	debugIdleColor     = color.NRGBA{R: 200, G: 200, B: 200, A: 255}
+)
+
+// SetDebugOverlay shows or hides the tile debug overlay: tile borders,
+// Z/X/Y labels, where each base layer tile came from, fetch latency and the
+// cache hit ratio.
+func (m *TileMapWidget) SetDebugOverlay(show bool) {
+	m.SetLayerVisible(debugLayerID, show)
+}
+
+func (m *TileMapWidget) DebugOverlay() bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return !m.hiddenLayers[debugLayerID]
+}
+
+// debugTile is what the overlay learned about one tile from tile events.
+type debugTile struct {
+	fromDisk bool
+	latency  time.Duration
+	err      error
+}
+
+// debugOverlay draws the tile grid of the base layer with each tile's state.
+type debugOverlay struct {
+	mapWidget *TileMapWidget
+
+	mu        sync.Mutex
+	tiles     map[TileCoord]debugTile
+	failures  int
+	fetches   int
+	fetchTime time.Duration
+
+	borders []*canvas.Rectangle
+	labels  []*canvas.Text
+	states  []*canvas.Text
+	summary *canvas.Text
+	box     *canvas.Rectangle
+}
+
+func newDebugOverlay() *debugOverlay {
+	summary := canvas.NewText("", color.White)
+	summary.TextSize = theme.CaptionTextSize()
+	return &debugOverlay{
+		tiles:   make(map[TileCoord]debugTile),
+		summary: summary,
+		box:     canvas.NewRectangle(overlayBackgroundColor),
+	}
+}
+
+func (o *debugOverlay) LayerID() string { return debugLayerID }
+
+func (o *debugOverlay) attach(m *TileMapWidget) { o.mapWidget = m }
+
+// record keeps the outcome of base layer events. It runs on the goroutine
+// that produced the event.
+func (o *debugOverlay) record(ev TileEvent) {
+	o.mapWidget.mu.RLock()
+	base := o.mapWidget.baseLayer
+	o.mapWidget.mu.RUnlock()
+	if base == nil || ev.Source != base.store.urlTemplate {
+		return
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	switch ev.Kind {
+	case TileCacheHit:
+		o.tiles[ev.Coord] = debugTile{fromDisk: true}
+	case TileLoaded:
+		o.tiles[ev.Coord] = debugTile{latency: ev.Elapsed}
+		o.fetches++
+		o.fetchTime += ev.Elapsed
+	case TileNotFound, TileFailed:
+		o.tiles[ev.Coord] = debugTile{latency: ev.Elapsed, err: ev.Err}
+		o.failures++
+	case TileCancelled:
+		delete(o.tiles, ev.Coord)
+	}
+}
+
+func (o *debugOverlay) refresh(view mapView) []fyne.CanvasObject {
+	base := o.mapWidget.baseLayer
+	if base == nil {
+		return nil
+	}
+	coords := view.visibleTiles()
+	for len(o.borders) < len(coords) {
+		border := canvas.NewRectangle(color.Transparent)
+		border.StrokeWidth = 1
+		label := canvas.NewText("", color.White)
+		label.TextSize = theme.CaptionTextSize()
+		label.TextStyle.Monospace = true
+		state := canvas.NewText("", color.White)
+		state.TextSize = theme.CaptionTextSize()
+		state.TextStyle.Monospace = true
+		o.borders = append(o.borders, border)
+		o.labels = append(o.labels, label)
+		o.states = append(o.states, state)
+	}
+
+	o.mu.Lock()
+	tiles := make(map[TileCoord]debugTile, len(coords))
+	for _, coord := range coords {
+		if info, ok := o.tiles[coord]; ok {
+			tiles[coord] = info
+		}
+	}
+	failures, fetches, fetchTime := o.failures, o.fetches, o.fetchTime
+	o.mu.Unlock()
+
+	objects := make([]fyne.CanvasObject, 0, len(coords)*3+2)
+	for i, coord := range coords {
+		x, y := view.tilePosition(coord)
+		if x+mapTileSize <= 0 || y+mapTileSize <= 0 || x >= view.width || y >= view.height {
+			continue
+		}
+		text, stateColor := debugTileState(base.store, coord, tiles[coord])
+		pos := fyne.NewPos(x, y)
+
+		border := o.borders[i]
+		border.StrokeColor = stateColor
+		border.Resize(fyne.NewSize(mapTileSize, mapTileSize))
+		border.Move(pos)
+		border.Refresh()
+
+		// Keep the labels of partly visible tiles on screen.
+		labelPos := fyne.NewPos(max(x, 0), max(y, 0))
+		label, state := o.labels[i], o.states[i]
+		label.Text = fmt.Sprintf("%d/%d/%d", coord.Z, coord.X, coord.Y)
+		label.Move(labelPos.AddXY(4, 2))
+		label.Refresh()
+		state.Text, state.Color = text, stateColor
+		state.Move(labelPos.AddXY(4, 4+label.MinSize().Height))
+		state.Refresh()
+		objects = append(objects, border, label, state)
+	}
+
+	stats := base.store.stats()
+	lookups := stats.memoryHits + stats.diskHits + stats.downloads
+	hitRatio := 0.0
+	if lookups > 0 {
+		hitRatio = float64(stats.memoryHits+stats.diskHits) / float64(lookups) * 100
+	}
+	avgFetch := "-"
+	if fetches > 0 {
+		avgFetch = (fetchTime / time.Duration(fetches)).Round(time.Millisecond).String()
+	}
+	o.summary.Text = fmt.Sprintf("cache hits %.0f%% (memory %d, disk %d, downloads %d)  failed %d  avg fetch %s",
+		hitRatio, stats.memoryHits, stats.diskHits, stats.downloads, failures, avgFetch)
+	o.summary.Refresh()
+	size := o.summary.MinSize().AddWidthHeight(theme.Padding()*2, theme.Padding())
+	boxPos := fyne.NewPos((view.width-size.Width)/2, theme.Padding())
+	o.box.Resize(size)
+	o.box.Move(boxPos)
+	o.summary.Move(boxPos.AddXY(theme.Padding(), theme.Padding()/2))
+	return append(objects, o.box, o.summary)
+}
+
+// debugTileState describes where a tile currently stands.
+func debugTileState(store *tileStore, coord TileCoord, info debugTile) (string, color.Color) {
+	cached, fetchingSince, fetching := store.status(coord)
+	switch {
+	case cached && info.fromDisk:
+		return "disk", debugDiskColor
+	case cached && info.latency > 0:
+		return fmt.Sprintf("memory, fetched in %s", info.latency.Round(time.Millisecond)), debugMemoryColor
+	case cached:
+		return "memory", debugMemoryColor
+	case fetching:
+		return fmt.Sprintf("fetching %s", time.Since(fetchingSince).Round(100*time.Millisecond)), debugFetchingColor
+	case info.err != nil:
+		return "failed: " + debugErrorText(info.err), debugFailedColor
+	default:
+		return "not loaded", debugIdleColor
+	}
+}
+
+func debugErrorText(err error) string {
+	var tileErr *TileError
+	switch {
+	case errors.Is(err, ErrTileNotFound):
+		return "not found"
+	case errors.Is(err, ErrTileUnauthorized):
+		return "unauthorized"
+	case errors.Is(err, ErrTileTimeout):
+		return "timeout"
+	case errors.As(err, &tileErr) && tileErr.Status != 0:
+		return fmt.Sprintf("HTTP %d", tileErr.Status)
+	default:
+		return "error"
+	}
+}
@@ -0,0 +1,368 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"math"
+	"os"
+	"strings"
+	"time"
+
+	"fyne.io/fyne/v2"
+	xdraw "golang.org/x/image/draw"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/basicfont"
+	"golang.org/x/image/math/fixed"
+	"golang.org/x/image/vector"
+)
+
+const (
+	exportTileTimeout = 10 * time.Second
+	exportMaxSize     = 8192
+	exportPadding     = 4
+	circleSegments    = 32
+)
+
+// mapBackgroundColor fills exported images where no tile has been drawn.
+var mapBackgroundColor = color.NRGBA{R: 0x17, G: 0x17, B: 0x18, A: 0xff}
+
+// imageLayer is implemented by layers that can draw themselves without a
+// canvas, for exporting the map to an image.
+type imageLayer interface {
+	drawImage(dst *image.RGBA, view mapView)
+}
+
+// RenderImage draws the map at its current center and zoom into a width x
+// height image without using the window. Tiles that are not cached yet are
+// fetched first, waiting at most exportTileTimeout; tiles that still fail are
+// left blank.
+func (m *TileMapWidget) RenderImage(width, height int) (*image.RGBA, error) {
+	if width <= 0 || height <= 0 || width > exportMaxSize || height > exportMaxSize {
+		return nil, fmt.Errorf("invalid export size %dx%d (max %d)", width, height, exportMaxSize)
+	}
+	m.mu.RLock()
+	view := mapView{zoom: m.zoom, centerLat: m.centerLat, centerLon: m.centerLon, width: float32(width), height: float32(height)}
+	m.mu.RUnlock()
+	return m.renderView(view), nil
+}
+
+// RenderVisibleImage draws the area the widget currently shows into a width
+// x height image, at the deepest zoom where all of it still fits. Tiles only
+// come in whole zoom levels, so the image may show a margin around that area.
+func (m *TileMapWidget) RenderVisibleImage(width, height int) (*image.RGBA, error) {
+	if width <= 0 || height <= 0 || width > exportMaxSize || height > exportMaxSize {
+		return nil, fmt.Errorf("invalid export size %dx%d (max %d)", width, height, exportMaxSize)
+	}
+	m.mu.RLock()
+	view := mapView{zoom: m.zoom, centerLat: m.centerLat, centerLon: m.centerLon, width: float32(width), height: float32(height)}
+	if m.width > 0 && m.height > 0 {
+		scale := math.Min(float64(width)/float64(m.width), float64(height)/float64(m.height))
+		view.zoom = m.clampZoomLocked(m.zoom + int(math.Floor(math.Log2(scale)+1e-9)))
+	}
+	m.mu.RUnlock()
+	return m.renderView(view), nil
+}
+
+// renderView renders the visible layers for view.
+func (m *TileMapWidget) renderView(view mapView) *image.RGBA {
+	m.mu.RLock()
+	layers := make([]MapLayer, 0, len(m.layers))
+	for _, layer := range m.layers {
+		if !m.hiddenLayers[layer.LayerID()] {
+			layers = append(layers, layer)
+		}
+	}
+	m.mu.RUnlock()
+
+	return renderLayers(layers, view, time.Now().Add(exportTileTimeout))
+}
+
+// ExportPNG renders the map with RenderImage and writes it to path.
+func (m *TileMapWidget) ExportPNG(path string, width, height int) error {
+	img, err := m.RenderImage(width, height)
+	if err != nil {
+		return err
+	}
+	return writePNG(path, img)
+}
+
+// renderLayers composites layers bottom to top for view. Layers without an
+// image form, like the on-map buttons, are skipped.
+func renderLayers(layers []MapLayer, view mapView, tileDeadline time.Time) *image.RGBA {
+	for _, layer := range layers {
+		if tiles, ok := layer.(*TileLayer); ok {
+			tiles.store.await(view.visibleTiles(), tileDeadline)
+		}
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, int(view.width), int(view.height)))
+	draw.Draw(dst, dst.Bounds(), image.NewUniform(mapBackgroundColor), image.Point{}, draw.Src)
+	for _, layer := range layers {
+		if drawer, ok := layer.(imageLayer); ok {
+			drawer.drawImage(dst, view)
+		}
+	}
+	return dst
+}
+
+func writePNG(path string, img image.Image) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("failed to create '%s': %w", path, err)
+	}
+	if err := png.Encode(file, img); err != nil {
+		file.Close()
+		return fmt.Errorf("failed to encode png '%s': %w", path, err)
+	}
+	if err := file.Close(); err != nil {
+		return fmt.Errorf("failed to close '%s': %w", path, err)
+	}
+	return nil
+}
+
+// await blocks until none of coords is being fetched, or until deadline.
+func (s *tileStore) await(coords []TileCoord, deadline time.Time) {
+	for {
+		pending := false
+		for _, coord := range coords {
+			if _, found := s.tile(coord); !found {
+				s.mu.RLock()
+				_, fetching := s.tileLoading[coord]
+				s.mu.RUnlock()
+				pending = pending || fetching
+			}
+		}
+		if !pending || time.Now().After(deadline) {
+			return
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+// =====================================================
+// Layer Drawing
+// =====================================================
+
+func (l *TileLayer) drawImage(dst *image.RGBA, view mapView) {
+	mask := image.NewUniform(color.Alpha{A: uint8(255 * clampUnit(float64(l.Opacity())))})
+	for _, coord := range view.visibleTiles() {
+		imgData, found := l.store.tile(coord)
+		if !found {
+			continue
+		}
+		x, y := view.tilePosition(coord)
+		r := image.Rect(0, 0, mapTileSize, mapTileSize).Add(image.Pt(int(math.Round(float64(x))), int(math.Round(float64(y)))))
+		draw.DrawMask(dst, r, imgData, imgData.Bounds().Min, mask, image.Point{}, draw.Over)
+	}
+}
+
+func (l *HeatmapLayer) drawImage(dst *image.RGBA, view mapView) {
+	l.mu.RLock()
+	samples, opacity, radius := l.samples, l.opacity, l.radius
+	l.mu.RUnlock()
+	if len(samples) == 0 || opacity <= 0 {
+		return
+	}
+	origin := view.pixelOrigin()
+	area := dst.Bounds().Sub(origin)
+	cells := heatmapCells(view.zoom, area, samples, opacity, radius)
+	r := image.Rect(0, 0, cells.Bounds().Dx()*heatmapCellSize, cells.Bounds().Dy()*heatmapCellSize).Add(area.Min.Add(origin))
+	xdraw.BiLinear.Scale(dst, r, cells, cells.Bounds(), xdraw.Over, nil)
+}
+
+func (l *VectorLayer) drawImage(dst *image.RGBA, view mapView) {
+	l.mu.RLock()
+	polylines := append([]*MapPolyline(nil), l.polylines...)
+	overlays := append([]*geoJSONOverlay(nil), l.geoJSON...)
+	l.mu.RUnlock()
+
+	rasterizeGeoFills(dst, projectGeoFills(overlays, view), 1)
+	lines := make([]*MapPolyline, 0, len(polylines))
+	for _, overlay := range overlays {
+		lines = append(lines, overlay.outlines...)
+	}
+	for _, line := range append(lines, polylines...) {
+		if len(line.Points) < 2 {
+			continue
+		}
+		lineColor := line.Color
+		if lineColor == nil {
+			lineColor = mapRouteColor
+		}
+		width := line.Width
+		if width <= 0 {
+			width = polylineDefaultWidth
+		}
+		strokePath(dst, view.projectPath(line.Points), width, lineColor)
+	}
+	for _, overlay := range overlays {
+		for _, feature := range overlay.features {
+			for _, p := range feature.points {
+				pos := view.projectPath([]LatLng{p})[0]
+				fillCircle(dst, pos, feature.style.PointRadius, feature.style.pointColor())
+			}
+		}
+	}
+}
+
+func (l *MarkerLayer) drawImage(dst *image.RGBA, view mapView) {
+	m := l.mapWidget
+	m.mu.RLock()
+	markers := append([]*MapMarker(nil), m.markers...)
+	selectedID := m.selectedID
+	userPos, userAccuracy := m.userPos, m.userAccuracy
+	m.mu.RUnlock()
+
+	if userPos != nil {
+		x, y := view.project(userPos.Lat, userPos.Lon)
+		pos := fyne.NewPos(x, y)
+		if radius := float32(userAccuracy / metersPerPixelAt(userPos.Lat, view.zoom)); radius > userDotRadius {
+			fillCircle(dst, pos, radius, userAccuracyFillColor)
+			strokeCircle(dst, pos, radius, 1, userLocationColor)
+		}
+		fillCircle(dst, pos, userDotRadius, color.White)
+		fillCircle(dst, pos, userDotRadius-2, userLocationColor)
+	}
+	for _, marker := range markers {
+		markerColor := mapMarkerColor
+		if marker.ID == selectedID {
+			markerColor = mapSelectedMarkerColor
+		}
+		x, y := view.project(marker.Lat, marker.Lon)
+		fillCircle(dst, fyne.NewPos(x, y), markerRadius, markerColor)
+	}
+}
+
+func (o *scaleBarOverlay) drawImage(dst *image.RGBA, view mapView) {
+	o.mapWidget.mu.RLock()
+	units := o.mapWidget.scaleUnits
+	o.mapWidget.mu.RUnlock()
+
+	bars := scaleBars(view, units)
+	if len(bars) == 0 {
+		return
+	}
+	face := basicfont.Face7x13
+	rowHeight := face.Height + exportPadding
+	boxW := 0
+	for _, b := range bars {
+		boxW = max(boxW, exportPadding*3+int(b.width)+font.MeasureString(face, b.label).Round())
+	}
+	boxH := rowHeight*len(bars) + exportPadding
+	x := exportPadding * 2
+	y := int(view.height) - boxH - exportPadding*2
+	draw.Draw(dst, image.Rect(x, y, x+boxW, y+boxH), image.NewUniform(overlayBackgroundColor), image.Point{}, draw.Over)
+
+	for i, b := range bars {
+		rowY := y + exportPadding/2 + rowHeight*i
+		barY := float32(rowY + rowHeight/2 + 2)
+		left, right := float32(x+exportPadding), float32(x+exportPadding)+b.width
+		strokePath(dst, []fyne.Position{fyne.NewPos(left, barY-4), fyne.NewPos(left, barY), fyne.NewPos(right, barY), fyne.NewPos(right, barY-4)}, 2, color.White)
+		drawText(dst, b.label, int(right)+exportPadding, rowY+face.Ascent+exportPadding/2, color.White)
+	}
+}
+
+func (o *attributionOverlay) drawImage(dst *image.RGBA, view mapView) {
+	links := o.mapWidget.attributions()
+	if len(links) == 0 {
+		return
+	}
+	texts := make([]string, len(links))
+	for i, link := range links {
+		texts[i] = link.Text
+	}
+	// The export font only covers ASCII.
+	text := strings.ReplaceAll(strings.Join(texts, "  "), "©", "(c)")
+	face := basicfont.Face7x13
+	w := font.MeasureString(face, text).Round() + exportPadding*2
+	h := face.Height + exportPadding*2
+	r := image.Rect(int(view.width)-w, int(view.height)-h, int(view.width), int(view.height))
+	draw.Draw(dst, r, image.NewUniform(overlayBackgroundColor), image.Point{}, draw.Over)
+	drawText(dst, text, r.Min.X+exportPadding, r.Min.Y+exportPadding+face.Ascent, color.White)
+}
+
+// =====================================================
+// Drawing Helpers
+// =====================================================
+
+// strokePath draws the line string pts with the given width, with round joins.
+func strokePath(dst draw.Image, pts []fyne.Position, width float32, c color.Color) {
+	b := dst.Bounds()
+	z := vector.NewRasterizer(b.Dx(), b.Dy())
+	half := width / 2
+	for i := 0; i+1 < len(pts); i++ {
+		a, e := pts[i], pts[i+1]
+		dx, dy := e.X-a.X, e.Y-a.Y
+		length := float32(math.Hypot(float64(dx), float64(dy)))
+		if length == 0 {
+			continue
+		}
+		nx, ny := -dy/length*half, dx/length*half
+		z.MoveTo(a.X+nx, a.Y+ny)
+		z.LineTo(e.X+nx, e.Y+ny)
+		z.LineTo(e.X-nx, e.Y-ny)
+		z.LineTo(a.X-nx, a.Y-ny)
+		z.ClosePath()
+	}
+	if width > 1 {
+		for _, p := range pts {
+			addCircle(z, p, half)
+		}
+	}
+	z.Draw(dst, b, image.NewUniform(c), image.Point{})
+}
+
+func fillCircle(dst draw.Image, center fyne.Position, radius float32, c color.Color) {
+	if radius <= 0 {
+		return
+	}
+	b := dst.Bounds()
+	z := vector.NewRasterizer(b.Dx(), b.Dy())
+	addCircle(z, center, radius)
+	z.Draw(dst, b, image.NewUniform(c), image.Point{})
+}
+
+// addCircle traces a circle with the same winding as the segments of
+// strokePath, so that overlapping joins do not cancel out.
+func strokeCircle(dst draw.Image, center fyne.Position, radius, width float32, c color.Color) {
+	b := dst.Bounds()
+	z := vector.NewRasterizer(b.Dx(), b.Dy())
+	addCircle(z, center, radius)
+	// The inner circle is traced backwards to cut a hole.
+	inner := radius - width
+	for i := 0; i <= circleSegments; i++ {
+		angle := 2 * math.Pi * float64(i) / circleSegments
+		x := center.X + inner*float32(math.Cos(angle))
+		y := center.Y + inner*float32(math.Sin(angle))
+		if i == 0 {
+			z.MoveTo(x, y)
+		} else {
+			z.LineTo(x, y)
+		}
+	}
+	z.ClosePath()
+	z.Draw(dst, b, image.NewUniform(c), image.Point{})
+}
+
+func addCircle(z *vector.Rasterizer, center fyne.Position, radius float32) {
+	for i := 0; i <= circleSegments; i++ {
+		angle := -2 * math.Pi * float64(i) / circleSegments
+		x := center.X + radius*float32(math.Cos(angle))
+		y := center.Y + radius*float32(math.Sin(angle))
+		if i == 0 {
+			z.MoveTo(x, y)
+		} else {
+			z.LineTo(x, y)
+		}
+	}
+	z.ClosePath()
+}
+
+// drawText draws text with its baseline starting at x, y.
+func drawText(dst draw.Image, text string, x, y int, c color.Color) {
+	d := &font.Drawer{Dst: dst, Src: image.NewUniform(c), Face: basicfont.Face7x13, Dot: fixed.P(x, y)}
+	d.DrawString(text)
+}
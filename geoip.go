@@ -0,0 +1,132 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net"
+
+	"github.com/oschwald/maxminddb-golang"
+)
+
+// geoIPDatabasePath is a MaxMind-format city database, e.g. GeoLite2-City.mmdb.
+const geoIPDatabasePath = "geoip/GeoLite2-City.mmdb"
+
+var errGeoIPNotFound = errors.New("address not in GeoIP database")
+
+// GeoIPLocation is where a GeoIP database places an address.
+type GeoIPLocation struct {
+	LatLng
+	AccuracyKm float64
+	City       string
+	Country    string
+	IP         net.IP
+}
+
+// locateDevice looks up the device's public addresses in the local GeoIP
+// database. No network calls are made, so this only works when an interface
+// has a public address: behind NAT the device only sees private addresses,
+// which are in no GeoIP database, and its location stays unknown.
+func locateDevice() (*GeoIPLocation, error) {
+	ips, err := publicDeviceIPs()
+	if err != nil {
+		return nil, err
+	}
+	if len(ips) == 0 {
+		return nil, fmt.Errorf("no interface has a public address (behind NAT?): %w", errGeoIPNotFound)
+	}
+	db, err := openGeoIP(geoIPDatabasePath)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+	for _, ip := range ips {
+		loc, err := lookupLocation(db, ip)
+		if err == nil {
+			return loc, nil
+		}
+		if !errors.Is(err, errGeoIPNotFound) {
+			return nil, err
+		}
+	}
+	return nil, fmt.Errorf("none of %d device addresses found in '%s': %w", len(ips), geoIPDatabasePath, errGeoIPNotFound)
+}
+
+// publicDeviceIPs returns the public unicast addresses of the network
+// interfaces.
+func publicDeviceIPs() ([]net.IP, error) {
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list interface addresses: %w", err)
+	}
+	var public []net.IP
+	for _, addr := range addrs {
+		ipNet, ok := addr.(*net.IPNet)
+		if ok && ipNet.IP.IsGlobalUnicast() && !ipNet.IP.IsPrivate() {
+			public = append(public, ipNet.IP)
+		}
+	}
+	return public, nil
+}
+
+// nearestMarker returns the marker closest to pos, or nil if there are none.
+func nearestMarker(pos LatLng, markers []*MapMarker) *MapMarker {
+	var nearest *MapMarker
+	best := math.Inf(1)
+	for _, marker := range markers {
+		if d := distanceMeters(pos, LatLng{Lat: marker.Lat, Lon: marker.Lon}); d < best {
+			nearest, best = marker, d
+		}
+	}
+	return nearest
+}
+
+// =====================================================
+// GeoIP Lookup
+// =====================================================
+
+// geoIPRecord is the part of a GeoLite2/GeoIP2 City record the app reads.
+type geoIPRecord struct {
+	City struct {
+		Names map[string]string `maxminddb:"names"`
+	} `maxminddb:"city"`
+	Country struct {
+		Names map[string]string `maxminddb:"names"`
+	} `maxminddb:"country"`
+	Location struct {
+		AccuracyRadius uint16   `maxminddb:"accuracy_radius"`
+		Latitude       *float64 `maxminddb:"latitude"`
+		Longitude      *float64 `maxminddb:"longitude"`
+	} `maxminddb:"location"`
+}
+
+func openGeoIP(path string) (*maxminddb.Reader, error) {
+	db, err := maxminddb.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open GeoIP database '%s': %w", path, err)
+	}
+	return db, nil
+}
+
+// lookupLocation reads the location, city and country of ip's record, or
+// returns errGeoIPNotFound.
+func lookupLocation(db *maxminddb.Reader, ip net.IP) (*GeoIPLocation, error) {
+	var record geoIPRecord
+	_, found, err := db.LookupNetwork(ip, &record)
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up %s in GeoIP database: %w", ip, err)
+	}
+	if !found {
+		return nil, errGeoIPNotFound
+	}
+	if record.Location.Latitude == nil || record.Location.Longitude == nil {
+		return nil, fmt.Errorf("GeoIP record for %s has no coordinates: %w", ip, errGeoIPNotFound)
+	}
+	return &GeoIPLocation{
+		LatLng:     LatLng{Lat: *record.Location.Latitude, Lon: *record.Location.Longitude},
+		AccuracyKm: float64(record.Location.AccuracyRadius),
+		City:       record.City.Names["en"],
+		Country:    record.Country.Names["en"],
+		IP:         ip,
+	}, nil
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"math"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var mmdbMetadataMarker = []byte("\xAB\xCD\xEFMaxMind.com")
+
+// mmdbPointer encodes as a pointer to an offset in the data section.
+type mmdbPointer int
+
+// mmdbMap encodes as a map, keeping the order of its entries.
+type mmdbMap [][2]any
+
+func writeMMDBControl(buf *bytes.Buffer, kind, size int) {
+	var sizeBits byte
+	var ext []byte
+	switch {
+	case size < 29:
+		sizeBits = byte(size)
+	case size < 285:
+		sizeBits = 29
+		ext = []byte{byte(size - 29)}
+	default:
+		sizeBits = 30
+		n := size - 285
+		ext = []byte{byte(n >> 8), byte(n)}
+	}
+	if kind <= 7 {
+		buf.WriteByte(byte(kind<<5) | sizeBits)
+	} else {
+		buf.WriteByte(sizeBits)
+		buf.WriteByte(byte(kind - 7))
+	}
+	buf.Write(ext)
+}
+
+func writeMMDBValue(buf *bytes.Buffer, v any) {
+	switch x := v.(type) {
+	case string:
+		writeMMDBControl(buf, 2, len(x))
+		buf.WriteString(x)
+	case float64:
+		writeMMDBControl(buf, 3, 8)
+		binary.Write(buf, binary.BigEndian, math.Float64bits(x))
+	case uint16:
+		writeMMDBControl(buf, 5, 2)
+		binary.Write(buf, binary.BigEndian, x)
+	case uint32:
+		writeMMDBControl(buf, 6, 4)
+		binary.Write(buf, binary.BigEndian, x)
+	case bool:
+		size := 0
+		if x {
+			size = 1
+		}
+		writeMMDBControl(buf, 14, size)
+	case []any:
+		writeMMDBControl(buf, 11, len(x))
+		for _, e := range x {
+			writeMMDBValue(buf, e)
+		}
+	case mmdbMap:
+		writeMMDBControl(buf, 7, len(x))
+		for _, kv := range x {
+			writeMMDBValue(buf, kv[0])
+			writeMMDBValue(buf, kv[1])
+		}
+	case mmdbPointer:
+		if p := int(x); p < 2048 {
+			buf.Write([]byte{1<<5 | byte(p>>8), byte(p)})
+		} else {
+			p -= 2048
+			buf.Write([]byte{1<<5 | 1<<3 | byte(p>>16&7), byte(p >> 8), byte(p)})
+		}
+	default:
+		panic("unsupported test value")
+	}
+}
+
+// buildTestMMDB returns an IPv6 database with recordSize-bit records that
+// maps the IPv4 network/24 to a city record.
+func buildTestMMDB(recordSize int, network net.IP) []byte {
+	bits := append(make([]byte, 12), network.To4()...)
+	nodeCount := 96 + 24
+
+	var data bytes.Buffer
+	// Padding moves the shared names past 2048 bytes, needing longer pointers.
+	writeMMDBValue(&data, strings.Repeat("-", 2100))
+	namesAt := data.Len()
+	writeMMDBValue(&data, mmdbMap{{"en", "Ho Chi Minh City"}})
+	recordAt := data.Len()
+	writeMMDBValue(&data, mmdbMap{
+		{"city", mmdbMap{{"names", mmdbPointer(namesAt)}, {"geoname_id", uint32(1566083)}}},
+		{"country", mmdbMap{{"iso_code", "VN"}, {"names", mmdbMap{{"en", "Vietnam"}}}}},
+		{"location", mmdbMap{{"accuracy_radius", uint16(20)}, {"latitude", 10.8231}, {"longitude", 106.6297}}},
+		{"is_anycast", false},
+	})
+
+	// One node per address bit; the other branch of each is empty.
+	var tree bytes.Buffer
+	for i := 0; i < nodeCount; i++ {
+		next := i + 1
+		if i == nodeCount-1 {
+			next = nodeCount + 16 + recordAt
+		}
+		left, right := nodeCount, nodeCount
+		if bits[i/8]>>(7-uint(i%8))&1 == 0 {
+			left = next
+		} else {
+			right = next
+		}
+		switch recordSize {
+		case 24:
+			tree.Write([]byte{byte(left >> 16), byte(left >> 8), byte(left), byte(right >> 16), byte(right >> 8), byte(right)})
+		case 28:
+			tree.Write([]byte{byte(left >> 16), byte(left >> 8), byte(left), byte(left>>24)<<4 | byte(right>>24)&0xF, byte(right >> 16), byte(right >> 8), byte(right)})
+		case 32:
+			binary.Write(&tree, binary.BigEndian, uint32(left))
+			binary.Write(&tree, binary.BigEndian, uint32(right))
+		}
+	}
+
+	var out bytes.Buffer
+	out.Write(tree.Bytes())
+	out.Write(make([]byte, 16))
+	out.Write(data.Bytes())
+	out.Write(mmdbMetadataMarker)
+	writeMMDBValue(&out, mmdbMap{
+		{"binary_format_major_version", uint16(2)},
+		{"database_type", "GeoLite2-City"},
+		{"ip_version", uint16(6)},
+		{"languages", []any{"en"}},
+		{"node_count", uint32(nodeCount)},
+		{"record_size", uint16(recordSize)},
+	})
+	return out.Bytes()
+}
+
+func writeTestMMDB(t *testing.T, db []byte) string {
+	path := filepath.Join(t.TempDir(), "test.mmdb")
+	if err := os.WriteFile(path, db, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLookupLocation(t *testing.T) {
+	for _, recordSize := range []int{24, 28, 32} {
+		db, err := openGeoIP(writeTestMMDB(t, buildTestMMDB(recordSize, net.ParseIP("203.0.113.0"))))
+		if err != nil {
+			t.Fatalf("%d-bit records: %v", recordSize, err)
+		}
+		defer db.Close()
+
+		loc, err := lookupLocation(db, net.ParseIP("203.0.113.77"))
+		if err != nil {
+			t.Fatalf("%d-bit records: %v", recordSize, err)
+		}
+		want := GeoIPLocation{LatLng: LatLng{Lat: 10.8231, Lon: 106.6297}, AccuracyKm: 20, City: "Ho Chi Minh City", Country: "Vietnam"}
+		if loc.LatLng != want.LatLng || loc.AccuracyKm != want.AccuracyKm || loc.City != want.City || loc.Country != want.Country {
+			t.Errorf("%d-bit records: got %+v, want %+v", recordSize, *loc, want)
+		}
+
+		for _, ip := range []string{"203.0.114.1", "2001:db8::1"} {
+			if _, err := lookupLocation(db, net.ParseIP(ip)); !errors.Is(err, errGeoIPNotFound) {
+				t.Errorf("%d-bit records: lookup of %s: got %v, want errGeoIPNotFound", recordSize, ip, err)
+			}
+		}
+	}
+}
+
+func TestOpenGeoIPRejectsBadFiles(t *testing.T) {
+	good := buildTestMMDB(24, net.ParseIP("203.0.113.0"))
+	markerAt := bytes.LastIndex(good, mmdbMetadataMarker)
+	for name, db := range map[string][]byte{
+		"no metadata":        good[:markerAt],
+		"truncated metadata": good[:len(good)-3],
+		"truncated tree":     append(append([]byte(nil), good[:100]...), good[markerAt:]...),
+		"record size":        buildTestMMDB(20, net.ParseIP("203.0.113.0")),
+	} {
+		if db, err := openGeoIP(writeTestMMDB(t, db)); err == nil {
+			db.Close()
+			t.Errorf("%s: opened without error", name)
+		}
+	}
+}
@@ -0,0 +1,471 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"strconv"
+	"strings"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/canvas"
+	"golang.org/x/image/vector"
+)
+
+// GeoJSONStyle is the default look of an overlay. Features may override it
+// with simplestyle-spec properties ("stroke", "stroke-width", "stroke-opacity",
+// "fill", "fill-opacity", "marker-color").
+type GeoJSONStyle struct {
+	StrokeColor color.Color
+	StrokeWidth float32
+	FillColor   color.Color
+	// MarkerColor colors points. Without it they take the stroke color.
+	MarkerColor color.Color
+	PointRadius float32
+}
+
+var defaultGeoJSONStyle = GeoJSONStyle{
+	StrokeColor: color.NRGBA{R: 0, G: 150, B: 255, A: 220},
+	StrokeWidth: 1.5,
+	FillColor:   color.NRGBA{R: 0, G: 150, B: 255, A: 50},
+	PointRadius: 4,
+}
+
+type geoJSONOverlay struct {
+	id       string
+	features []geoFeature
+	outlines []*MapPolyline
+}
+
+type geoFeature struct {
+	points   []LatLng
+	lines    [][]LatLng
+	polygons [][][]LatLng
+	style    GeoJSONStyle
+}
+
+// positions returns every coordinate of the overlay.
+func (o *geoJSONOverlay) positions() []LatLng {
+	var all []LatLng
+	for _, feature := range o.features {
+		all = append(all, feature.points...)
+		for _, line := range feature.lines {
+			all = append(all, line...)
+		}
+		for _, polygon := range feature.polygons {
+			for _, ring := range polygon {
+				all = append(all, ring...)
+			}
+		}
+	}
+	return all
+}
+
+type geoJSONObject struct {
+	Type        string          `json:"type"`
+	Features    []geoJSONObject `json:"features"`
+	Geometry    *geoJSONObject  `json:"geometry"`
+	Geometries  []geoJSONObject `json:"geometries"`
+	Coordinates json.RawMessage `json:"coordinates"`
+	Properties  map[string]any  `json:"properties"`
+}
+
+// AddGeoJSON parses a GeoJSON FeatureCollection, Feature or geometry and adds
+// it as an overlay, replacing any overlay with the same ID.
+func (l *VectorLayer) AddGeoJSON(id string, data []byte, style GeoJSONStyle) error {
+	overlay, err := parseGeoJSONOverlay(id, data, style)
+	if err != nil {
+		return err
+	}
+	l.mu.Lock()
+	replaced := false
+	for i, existing := range l.geoJSON {
+		if existing.id == id {
+			l.geoJSON[i] = overlay
+			replaced = true
+			break
+		}
+	}
+	if !replaced {
+		l.geoJSON = append(l.geoJSON, overlay)
+	}
+	l.mu.Unlock()
+	l.changed()
+	return nil
+}
+
+func (l *VectorLayer) RemoveGeoJSON(id string) bool {
+	l.mu.Lock()
+	removed := false
+	for i, existing := range l.geoJSON {
+		if existing.id == id {
+			l.geoJSON = append(l.geoJSON[:i], l.geoJSON[i+1:]...)
+			removed = true
+			break
+		}
+	}
+	l.mu.Unlock()
+	if removed {
+		l.changed()
+	}
+	return removed
+}
+
+// AddGeoJSON adds an overlay to the map's default vector layer.
+func (m *TileMapWidget) AddGeoJSON(id string, data []byte, style GeoJSONStyle) error {
+	return m.vectorLayer.AddGeoJSON(id, data, style)
+}
+
+func (m *TileMapWidget) RemoveGeoJSON(id string) bool {
+	return m.vectorLayer.RemoveGeoJSON(id)
+}
+
+func parseGeoJSONOverlay(id string, data []byte, style GeoJSONStyle) (*geoJSONOverlay, error) {
+	var root geoJSONObject
+	if err := json.Unmarshal(data, &root); err != nil {
+		return nil, fmt.Errorf("geojson '%s': %w", id, err)
+	}
+	style = mergeGeoJSONStyle(defaultGeoJSONStyle, style)
+	overlay := &geoJSONOverlay{id: id}
+	if err := overlay.addObject(root, style); err != nil {
+		return nil, fmt.Errorf("geojson '%s': %w", id, err)
+	}
+
+	for i, feature := range overlay.features {
+		outline := func(points []LatLng) {
+			overlay.outlines = append(overlay.outlines, &MapPolyline{
+				ID:     fmt.Sprintf("geojson/%s/%d/%d", id, i, len(overlay.outlines)),
+				Points: points,
+				Color:  feature.style.StrokeColor,
+				Width:  feature.style.StrokeWidth,
+			})
+		}
+		for _, line := range feature.lines {
+			outline(line)
+		}
+		for _, polygon := range feature.polygons {
+			for _, ring := range polygon {
+				outline(ring)
+			}
+		}
+	}
+	return overlay, nil
+}
+
+func (o *geoJSONOverlay) addObject(obj geoJSONObject, style GeoJSONStyle) error {
+	switch obj.Type {
+	case "FeatureCollection":
+		for _, feature := range obj.Features {
+			if err := o.addObject(feature, style); err != nil {
+				return err
+			}
+		}
+		return nil
+	case "Feature":
+		if obj.Geometry == nil {
+			return nil
+		}
+		return o.addGeometry(*obj.Geometry, featureStyle(style, obj.Properties))
+	default:
+		return o.addGeometry(obj, style)
+	}
+}
+
+func (o *geoJSONOverlay) addGeometry(geom geoJSONObject, style GeoJSONStyle) error {
+	feature := geoFeature{style: style}
+	var err error
+	switch geom.Type {
+	case "Point":
+		var c []float64
+		if err = json.Unmarshal(geom.Coordinates, &c); err == nil {
+			var p LatLng
+			p, err = geoJSONPosition(c)
+			feature.points = []LatLng{p}
+		}
+	case "MultiPoint":
+		var c [][]float64
+		if err = json.Unmarshal(geom.Coordinates, &c); err == nil {
+			feature.points, err = geoJSONPositions(c)
+		}
+	case "LineString":
+		var c [][]float64
+		if err = json.Unmarshal(geom.Coordinates, &c); err == nil {
+			var line []LatLng
+			line, err = geoJSONPositions(c)
+			feature.lines = [][]LatLng{line}
+		}
+	case "MultiLineString", "Polygon":
+		var c [][][]float64
+		if err = json.Unmarshal(geom.Coordinates, &c); err == nil {
+			var rings [][]LatLng
+			rings, err = geoJSONRings(c)
+			if geom.Type == "Polygon" {
+				feature.polygons = [][][]LatLng{rings}
+			} else {
+				feature.lines = rings
+			}
+		}
+	case "MultiPolygon":
+		var c [][][][]float64
+		if err = json.Unmarshal(geom.Coordinates, &c); err == nil {
+			for _, polygon := range c {
+				var rings [][]LatLng
+				if rings, err = geoJSONRings(polygon); err != nil {
+					break
+				}
+				feature.polygons = append(feature.polygons, rings)
+			}
+		}
+	case "GeometryCollection":
+		for _, child := range geom.Geometries {
+			if err := o.addGeometry(child, style); err != nil {
+				return err
+			}
+		}
+		return nil
+	default:
+		return fmt.Errorf("unsupported geometry type %q", geom.Type)
+	}
+	if err != nil {
+		return fmt.Errorf("%s coordinates: %w", geom.Type, err)
+	}
+	o.features = append(o.features, feature)
+	return nil
+}
+
+func geoJSONPosition(c []float64) (LatLng, error) {
+	if len(c) < 2 {
+		return LatLng{}, fmt.Errorf("position needs 2 values, got %d", len(c))
+	}
+	return LatLng{Lat: c[1], Lon: c[0]}, nil
+}
+
+func geoJSONPositions(coords [][]float64) ([]LatLng, error) {
+	points := make([]LatLng, 0, len(coords))
+	for _, c := range coords {
+		p, err := geoJSONPosition(c)
+		if err != nil {
+			return nil, err
+		}
+		points = append(points, p)
+	}
+	return points, nil
+}
+
+func geoJSONRings(coords [][][]float64) ([][]LatLng, error) {
+	rings := make([][]LatLng, 0, len(coords))
+	for _, c := range coords {
+		ring, err := geoJSONPositions(c)
+		if err != nil {
+			return nil, err
+		}
+		rings = append(rings, ring)
+	}
+	return rings, nil
+}
+
+func mergeGeoJSONStyle(base, override GeoJSONStyle) GeoJSONStyle {
+	if override.StrokeColor != nil {
+		base.StrokeColor = override.StrokeColor
+	}
+	if override.StrokeWidth > 0 {
+		base.StrokeWidth = override.StrokeWidth
+	}
+	if override.FillColor != nil {
+		base.FillColor = override.FillColor
+	}
+	if override.MarkerColor != nil {
+		base.MarkerColor = override.MarkerColor
+	}
+	if override.PointRadius > 0 {
+		base.PointRadius = override.PointRadius
+	}
+	return base
+}
+
+func featureStyle(style GeoJSONStyle, props map[string]any) GeoJSONStyle {
+	if props == nil {
+		return style
+	}
+	stroke := toNRGBA(style.StrokeColor)
+	if c, ok := parseHexColor(props["stroke"]); ok {
+		stroke.R, stroke.G, stroke.B = c.R, c.G, c.B
+	}
+	if a, ok := props["stroke-opacity"].(float64); ok {
+		stroke.A = uint8(clampUnit(a) * 255)
+	}
+	style.StrokeColor = stroke
+
+	fill := toNRGBA(style.FillColor)
+	if c, ok := parseHexColor(props["fill"]); ok {
+		fill.R, fill.G, fill.B = c.R, c.G, c.B
+	}
+	if a, ok := props["fill-opacity"].(float64); ok {
+		fill.A = uint8(clampUnit(a) * 255)
+	}
+	style.FillColor = fill
+
+	if w, ok := props["stroke-width"].(float64); ok && w > 0 {
+		style.StrokeWidth = float32(w)
+	}
+	if c, ok := parseHexColor(props["marker-color"]); ok {
+		style.MarkerColor = c
+	}
+	return style
+}
+
+// pointColor is the color of the feature's points.
+func (s GeoJSONStyle) pointColor() color.Color {
+	if s.MarkerColor != nil {
+		return s.MarkerColor
+	}
+	return s.StrokeColor
+}
+
+func toNRGBA(c color.Color) color.NRGBA {
+	return color.NRGBAModel.Convert(c).(color.NRGBA)
+}
+
+func clampUnit(v float64) float64 {
+	if v < 0 {
+		return 0
+	}
+	if v > 1 {
+		return 1
+	}
+	return v
+}
+
+// parseHexColor accepts "#rgb" and "#rrggbb" strings.
+func parseHexColor(v any) (color.NRGBA, bool) {
+	s, ok := v.(string)
+	if !ok {
+		return color.NRGBA{}, false
+	}
+	s = strings.TrimPrefix(s, "#")
+	if len(s) == 3 {
+		s = string([]byte{s[0], s[0], s[1], s[1], s[2], s[2]})
+	}
+	if len(s) != 6 {
+		return color.NRGBA{}, false
+	}
+	rgb, err := strconv.ParseUint(s, 16, 32)
+	if err != nil {
+		return color.NRGBA{}, false
+	}
+	return color.NRGBA{R: uint8(rgb >> 16), G: uint8(rgb >> 8), B: uint8(rgb), A: 255}, true
+}
+
+// geoFill is a polygon projected to screen coordinates, ready to rasterize.
+type geoFill struct {
+	rings [][]fyne.Position
+	color color.Color
+}
+
+// refreshGeoJSON projects all overlays for the current view and returns the
+// fill raster, the outline line strings and the point circles, bottom to top.
+func (l *VectorLayer) refreshGeoJSON(overlays []*geoJSONOverlay, view mapView) (fill fyne.CanvasObject, outlines []*MapPolyline, points []fyne.CanvasObject) {
+	l.geoFills = projectGeoFills(overlays, view)
+	l.viewWidth = view.width
+	circles := 0
+	for _, overlay := range overlays {
+		outlines = append(outlines, overlay.outlines...)
+		for _, feature := range overlay.features {
+			for _, p := range feature.points {
+				if circles == len(l.geoPoints) {
+					l.geoPoints = append(l.geoPoints, canvas.NewCircle(feature.style.pointColor()))
+				}
+				circle := l.geoPoints[circles]
+				circles++
+				pos := view.projectPath([]LatLng{p})[0]
+				radius := feature.style.PointRadius
+				circle.FillColor = feature.style.pointColor()
+				circle.Resize(fyne.NewSize(radius*2, radius*2))
+				circle.Move(fyne.NewPos(pos.X-radius, pos.Y-radius))
+				circle.Refresh()
+				points = append(points, circle)
+			}
+		}
+	}
+	l.geoPoints = l.geoPoints[:circles]
+
+	if len(l.geoFills) == 0 {
+		return nil, outlines, points
+	}
+	if l.geoFillRaster == nil {
+		l.geoFillRaster = canvas.NewRaster(l.drawGeoFills)
+	}
+	l.geoFillRaster.Resize(fyne.NewSize(view.width, view.height))
+	l.geoFillRaster.Move(fyne.NewPos(0, 0))
+	l.geoFillRaster.Refresh()
+	return l.geoFillRaster, outlines, points
+}
+
+// projectGeoFills projects the polygons of all overlays for view.
+func projectGeoFills(overlays []*geoJSONOverlay, view mapView) []geoFill {
+	fills := make([]geoFill, 0)
+	for _, overlay := range overlays {
+		for _, feature := range overlay.features {
+			for _, polygon := range feature.polygons {
+				projected := geoFill{color: feature.style.FillColor}
+				for _, ring := range polygon {
+					if len(ring) >= 3 {
+						projected.rings = append(projected.rings, view.projectPath(ring))
+					}
+				}
+				if len(projected.rings) > 0 {
+					fills = append(fills, projected)
+				}
+			}
+		}
+	}
+	return fills
+}
+
+// drawGeoFills rasterizes the projected polygons at the raster's pixel size.
+func (l *VectorLayer) drawGeoFills(w, h int) image.Image {
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	if l.viewWidth <= 0 || len(l.geoFills) == 0 {
+		return dst
+	}
+	rasterizeGeoFills(dst, l.geoFills, float32(w)/l.viewWidth)
+	return dst
+}
+
+// rasterizeGeoFills draws fills over dst, scaling view coordinates by scale.
+// Hole rings are wound against their exterior ring so they cut out the fill.
+func rasterizeGeoFills(dst draw.Image, fills []geoFill, scale float32) {
+	w, h := dst.Bounds().Dx(), dst.Bounds().Dy()
+	z := vector.NewRasterizer(w, h)
+	for _, fill := range fills {
+		z.Reset(w, h)
+		exteriorCCW := ringArea(fill.rings[0]) > 0
+		for i, ring := range fill.rings {
+			reverse := i > 0 && (ringArea(ring) > 0) == exteriorCCW
+			for j := range ring {
+				p := ring[j]
+				if reverse {
+					p = ring[len(ring)-1-j]
+				}
+				if j == 0 {
+					z.MoveTo(p.X*scale, p.Y*scale)
+				} else {
+					z.LineTo(p.X*scale, p.Y*scale)
+				}
+			}
+			z.ClosePath()
+		}
+		z.Draw(dst, dst.Bounds(), image.NewUniform(fill.color), image.Point{})
+	}
+}
+
+func ringArea(ring []fyne.Position) float32 {
+	area := float32(0)
+	for i := range ring {
+		a, b := ring[i], ring[(i+1)%len(ring)]
+		area += a.X*b.Y - b.X*a.Y
+	}
+	return area / 2
+}
@@ -0,0 +1,264 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"sync"
+	"time"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/canvas"
+)
+
+const (
+	heatmapCellSize      = 8
+	heatmapIDWPower      = 2
+	defaultHeatmapRadius = 1500e3 // meters
+)
+
+// heatmapRamp runs from the lowest sampled latency to the highest.
+var heatmapRamp = []color.NRGBA{
+	{R: 0, G: 200, B: 80, A: 255},
+	{R: 255, G: 210, B: 0, A: 255},
+	{R: 230, G: 40, B: 40, A: 255},
+}
+
+// LatencySample is the latency to the nearest gateway measured at Pos.
+type LatencySample struct {
+	Pos     LatLng
+	Latency time.Duration
+}
+
+// HeatmapLayer colors the map by latency, interpolated between samples with
+// inverse distance weighting. Areas farther than the radius from every sample
+// fade out so that the colors only claim what the data covers.
+type HeatmapLayer struct {
+	id string
+
+	mu        sync.RWMutex
+	samples   []LatencySample
+	opacity   float32
+	radius    float64
+	version   int
+	mapWidget *TileMapWidget
+
+	// The field is only touched on the UI thread. It covers more than the
+	// view so that drags just move the image; a new one is computed off the
+	// UI thread when the view leaves it, the zoom changes or the layer is
+	// updated.
+	image   *canvas.Image
+	field   heatmapField
+	pending *heatmapField
+}
+
+// heatmapField is the colored field for one zoom and layer version, covering
+// area in world pixels.
+type heatmapField struct {
+	zoom, version int
+	area          image.Rectangle
+	cells         *image.NRGBA
+}
+
+func NewHeatmapLayer(id string, opacity float32) *HeatmapLayer {
+	img := canvas.NewImageFromImage(image.NewNRGBA(image.Rect(0, 0, 1, 1)))
+	img.ScaleMode, img.FillMode = canvas.ImageScaleSmooth, canvas.ImageFillStretch
+	return &HeatmapLayer{
+		id:      id,
+		opacity: float32(clampUnit(float64(opacity))),
+		radius:  defaultHeatmapRadius,
+		image:   img,
+		field:   heatmapField{version: -1},
+	}
+}
+
+func (l *HeatmapLayer) LayerID() string { return l.id }
+
+func (l *HeatmapLayer) attach(m *TileMapWidget) {
+	l.mu.Lock()
+	l.mapWidget = m
+	l.mu.Unlock()
+}
+
+// update applies fn under the lock and redraws the map.
+func (l *HeatmapLayer) update(fn func()) {
+	l.mu.Lock()
+	fn()
+	l.version++
+	m := l.mapWidget
+	l.mu.Unlock()
+	if m != nil {
+		m.Refresh()
+	}
+}
+
+// SetSamples replaces the latency measurements the heatmap is built from.
+func (l *HeatmapLayer) SetSamples(samples ...LatencySample) {
+	l.update(func() { l.samples = append([]LatencySample(nil), samples...) })
+}
+
+func (l *HeatmapLayer) Samples() []LatencySample {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return append([]LatencySample(nil), l.samples...)
+}
+
+func (l *HeatmapLayer) Opacity() float32 {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.opacity
+}
+
+// SetOpacity sets the heatmap opacity between 0 (invisible) and 1 (opaque).
+func (l *HeatmapLayer) SetOpacity(opacity float32) {
+	l.update(func() { l.opacity = float32(clampUnit(float64(opacity))) })
+}
+
+// SetRadius sets how far in meters a sample's color reaches before fading out.
+func (l *HeatmapLayer) SetRadius(meters float64) {
+	if meters <= 0 {
+		return
+	}
+	l.update(func() { l.radius = meters })
+}
+
+// LatencyAt returns the interpolated latency at pos, or false if pos is
+// outside the radius of every sample.
+func (l *HeatmapLayer) LatencyAt(pos LatLng) (time.Duration, bool) {
+	l.mu.RLock()
+	samples, radius := l.samples, l.radius
+	l.mu.RUnlock()
+	latency, nearest := interpolateLatency(pos, samples)
+	if len(samples) == 0 || nearest > radius {
+		return 0, false
+	}
+	return time.Duration(latency), true
+}
+
+func (l *HeatmapLayer) refresh(view mapView) []fyne.CanvasObject {
+	l.mu.RLock()
+	samples, opacity, radius, version, m := l.samples, l.opacity, l.radius, l.version, l.mapWidget
+	l.mu.RUnlock()
+	if len(samples) == 0 || opacity <= 0 {
+		return nil
+	}
+
+	origin := view.pixelOrigin()
+	visible := image.Rect(0, 0, int(math.Ceil(float64(view.width))), int(math.Ceil(float64(view.height)))).Sub(origin)
+	if l.field.zoom != view.zoom || l.field.version != version || !visible.In(l.field.area) {
+		l.computeField(m, view.zoom, version, visible, samples, opacity, radius)
+	}
+	if l.field.cells == nil || l.field.zoom != view.zoom {
+		return nil
+	}
+
+	area := l.field.area.Add(origin)
+	if l.image.Image != l.field.cells {
+		l.image.Image = l.field.cells
+		l.image.Refresh()
+	}
+	l.image.Resize(fyne.NewSize(float32(area.Dx()), float32(area.Dy())))
+	l.image.Move(fyne.NewPos(float32(area.Min.X), float32(area.Min.Y)))
+	return []fyne.CanvasObject{l.image}
+}
+
+// computeField colors the area around visible off the UI thread and redraws
+// the map once it is done, unless a field for a later view was asked for in
+// the meantime.
+func (l *HeatmapLayer) computeField(m *TileMapWidget, zoom, version int, visible image.Rectangle, samples []LatencySample, opacity float32, radius float64) {
+	if p := l.pending; p != nil && p.zoom == zoom && p.version == version && visible.In(p.area) {
+		return
+	}
+	margin := image.Pt(visible.Dx()/2, visible.Dy()/2)
+	area := image.Rectangle{Min: visible.Min.Sub(margin), Max: visible.Max.Add(margin)}
+	area.Min = image.Pt(floorDiv(area.Min.X, heatmapCellSize), floorDiv(area.Min.Y, heatmapCellSize)).Mul(heatmapCellSize)
+	area.Max = image.Pt(-floorDiv(-area.Max.X, heatmapCellSize), -floorDiv(-area.Max.Y, heatmapCellSize)).Mul(heatmapCellSize)
+	pending := &heatmapField{zoom: zoom, version: version, area: area}
+	l.pending = pending
+
+	go func() {
+		cells := heatmapCells(zoom, area, samples, opacity, radius)
+		fyne.Do(func() {
+			if l.pending != pending {
+				return
+			}
+			pending.cells = cells
+			l.field, l.pending = *pending, nil
+			if m != nil {
+				m.Refresh()
+			}
+		})
+	}()
+}
+
+func floorDiv(a, b int) int {
+	if a < 0 {
+		return -((-a + b - 1) / b)
+	}
+	return a / b
+}
+
+// heatmapCells colors one pixel per heatmapCellSize square of area, given in
+// world pixels at zoom.
+func heatmapCells(zoom int, area image.Rectangle, samples []LatencySample, opacity float32, radius float64) *image.NRGBA {
+	low, high := samples[0].Latency, samples[0].Latency
+	for _, s := range samples[1:] {
+		low = min(low, s.Latency)
+		high = max(high, s.Latency)
+	}
+
+	cols := (area.Dx() + heatmapCellSize - 1) / heatmapCellSize
+	rows := (area.Dy() + heatmapCellSize - 1) / heatmapCellSize
+	img := image.NewNRGBA(image.Rect(0, 0, cols, rows))
+	for row := 0; row < rows; row++ {
+		for col := 0; col < cols; col++ {
+			x := float64(area.Min.X) + (float64(col)+0.5)*heatmapCellSize
+			y := float64(area.Min.Y) + (float64(row)+0.5)*heatmapCellSize
+			lat, lon := tileXYToLatLon(x/mapTileSize, y/mapTileSize, zoom)
+			latency, nearest := interpolateLatency(LatLng{Lat: lat, Lon: lon}, samples)
+			fade := 1 - nearest/radius
+			if fade <= 0 {
+				continue
+			}
+			t := 0.0
+			if high > low {
+				t = (latency - float64(low)) / float64(high-low)
+			}
+			c := heatmapColor(t)
+			c.A = uint8(float64(c.A) * float64(opacity) * clampUnit(fade))
+			img.SetNRGBA(col, row, c)
+		}
+	}
+	return img
+}
+
+// interpolateLatency returns the inverse-distance weighted latency at pos in
+// nanoseconds and the distance in meters to the nearest sample.
+func interpolateLatency(pos LatLng, samples []LatencySample) (float64, float64) {
+	nearest := math.Inf(1)
+	var weighted, totalWeight float64
+	for _, s := range samples {
+		d := distanceMeters(pos, s.Pos)
+		nearest = math.Min(nearest, d)
+		if d < 1 {
+			return float64(s.Latency), d
+		}
+		w := 1 / math.Pow(d, heatmapIDWPower)
+		weighted += w * float64(s.Latency)
+		totalWeight += w
+	}
+	if totalWeight == 0 {
+		return 0, nearest
+	}
+	return weighted / totalWeight, nearest
+}
+
+// heatmapColor maps t in [0, 1] onto heatmapRamp.
+func heatmapColor(t float64) color.NRGBA {
+	t = clampUnit(t) * float64(len(heatmapRamp)-1)
+	i := int(math.Min(math.Floor(t), float64(len(heatmapRamp)-2)))
+	f := t - float64(i)
+	a, b := heatmapRamp[i], heatmapRamp[i+1]
+	mix := func(x, y uint8) uint8 { return uint8(float64(x) + (float64(y)-float64(x))*f) }
+	return color.NRGBA{R: mix(a.R, b.R), G: mix(a.G, b.G), B: mix(a.B, b.B), A: mix(a.A, b.A)}
+}
@@ -0,0 +1,574 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+	"sync"
+	"time"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/canvas"
+)
+
+const (
+	baseLayerID   = "base"
+	vectorLayerID = "vector"
+	markerLayerID = "markers"
+)
+
+// MapLayer is one level of the map's layer stack. Layers are drawn bottom to
+// top: raster tile layers, then heatmaps, then vector layers, then the marker
+// layer, then the built-in scale bar, attribution, control and debug overlays.
+type MapLayer interface {
+	LayerID() string
+	// attach is called when the layer is added to a map widget.
+	attach(m *TileMapWidget)
+	// refresh positions the layer's canvas objects for view and returns them
+	// bottom to top. It is only called from the renderer.
+	refresh(view mapView) []fyne.CanvasObject
+}
+
+// layerRank orders layer kinds within the stack.
+func layerRank(layer MapLayer) int {
+	switch layer.(type) {
+	case *TileLayer:
+		return 0
+	case *HeatmapLayer:
+		return 1
+	case *MarkerLayer:
+		return 3
+	case *scaleBarOverlay, *attributionOverlay, *mapControlsOverlay, *debugOverlay:
+		return 4
+	default:
+		return 2
+	}
+}
+
+// AddLayer inserts layer above all layers of the same kind, replacing any
+// layer with the same ID.
+func (m *TileMapWidget) AddLayer(layer MapLayer) {
+	if layer == nil {
+		return
+	}
+	layer.attach(m)
+	m.mu.Lock()
+	m.removeLayerLocked(layer.LayerID())
+	insertAt := 0
+	for i, existing := range m.layers {
+		if layerRank(existing) <= layerRank(layer) {
+			insertAt = i + 1
+		}
+	}
+	m.layers = append(m.layers, nil)
+	copy(m.layers[insertAt+1:], m.layers[insertAt:])
+	m.layers[insertAt] = layer
+	if tiles, ok := layer.(*TileLayer); ok && m.subscribed {
+		tiles.store.subscribe(m)
+	}
+	m.mu.Unlock()
+	m.Refresh()
+}
+
+func (m *TileMapWidget) RemoveLayer(id string) bool {
+	m.mu.Lock()
+	removed := m.removeLayerLocked(id)
+	m.mu.Unlock()
+	if removed {
+		m.Refresh()
+	}
+	return removed
+}
+
+func (m *TileMapWidget) removeLayerLocked(id string) bool {
+	for i, existing := range m.layers {
+		if existing.LayerID() == id {
+			if tiles, ok := existing.(*TileLayer); ok {
+				if m.subscribed {
+					tiles.store.unsubscribe(m)
+				}
+				tiles.stopFades()
+			}
+			m.layers = append(m.layers[:i], m.layers[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+func (m *TileMapWidget) Layer(id string) MapLayer {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	for _, layer := range m.layers {
+		if layer.LayerID() == id {
+			return layer
+		}
+	}
+	return nil
+}
+
+func (m *TileMapWidget) SetLayerVisible(id string, visible bool) {
+	m.mu.Lock()
+	if visible {
+		delete(m.hiddenLayers, id)
+	} else {
+		m.hiddenLayers[id] = true
+	}
+	m.mu.Unlock()
+	m.Refresh()
+}
+
+// mapView is a snapshot of the camera used to project coordinates for one refresh.
+type mapView struct {
+	zoom      int
+	centerLat float64
+	centerLon float64
+	width     float32
+	height    float32
+}
+
+func (m *TileMapWidget) currentView() mapView {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return mapView{zoom: m.zoom, centerLat: m.centerLat, centerLon: m.centerLon, width: m.width, height: m.height}
+}
+
+// watchView registers fn to be called with the new view whenever the map's
+// center, zoom, size or base style changes.
+func (m *TileMapWidget) watchView(fn func(view mapView)) {
+	m.mu.Lock()
+	m.viewWatchers = append(m.viewWatchers, fn)
+	m.mu.Unlock()
+}
+
+func (v mapView) project(lat, lon float64) (float32, float32) {
+	n := math.Pow(2.0, float64(v.zoom))
+	pxX := ((lon + 180.0) / 360.0) * n * mapTileSize
+	pxY := (1.0 - math.Log(math.Tan(lat*math.Pi/180.0)+1.0/math.Cos(lat*math.Pi/180.0))/math.Pi) / 2.0 * n * mapTileSize
+	centerPxX := ((v.centerLon + 180.0) / 360.0) * n * mapTileSize
+	centerPxY := (1.0 - math.Log(math.Tan(v.centerLat*math.Pi/180.0)+1.0/math.Cos(v.centerLat*math.Pi/180.0))/math.Pi) / 2.0 * n * mapTileSize
+	return (v.width / 2.0) + float32(pxX-centerPxX), (v.height / 2.0) + float32(pxY-centerPxY)
+}
+
+func (v mapView) unproject(screenX, screenY float32) LatLng {
+	centerX, centerY := latLonToTileXY(v.centerLat, v.centerLon, v.zoom)
+	tileX := centerX + float64(screenX-v.width/2.0)/mapTileSize
+	tileY := centerY + float64(screenY-v.height/2.0)/mapTileSize
+	lat, lon := tileXYToLatLon(tileX, tileY, v.zoom)
+	return LatLng{Lat: lat, Lon: lon}
+}
+
+// projectPath projects points to screen coordinates, shifting the whole path
+// by a multiple of 360° so that it is drawn on the world copy nearest the center.
+func (v mapView) projectPath(points []LatLng) []fyne.Position {
+	if len(points) == 0 {
+		return nil
+	}
+	shift := math.Round((v.centerLon-points[0].Lon)/360.0) * 360.0
+	screenPts := make([]fyne.Position, len(points))
+	for i, p := range points {
+		x, y := v.project(p.Lat, p.Lon+shift)
+		screenPts[i] = fyne.NewPos(x, y)
+	}
+	return screenPts
+}
+
+func (v mapView) visibleTiles() []TileCoord {
+	centerX, centerY := latLonToTileXY(v.centerLat, v.centerLon, v.zoom)
+	tilesX := int(math.Ceil(float64(v.width)/mapTileSize)) + 2
+	tilesY := int(math.Ceil(float64(v.height)/mapTileSize)) + 2
+	startX := int(math.Floor(centerX - float64(tilesX)/2.0))
+	startY := int(math.Floor(centerY - float64(tilesY)/2.0))
+	tiles := make([]TileCoord, 0, tilesX*tilesY)
+	maxTile := int(math.Pow(2, float64(v.zoom))) - 1
+	for x := startX; x < startX+tilesX; x++ {
+		for y := startY; y < startY+tilesY; y++ {
+			if y < 0 || y > maxTile {
+				continue
+			}
+			wrappedX := x
+			if maxTile >= 0 {
+				nWrap := maxTile + 1
+				wrappedX = (x%nWrap + nWrap) % nWrap
+			} else {
+				wrappedX = 0
+			}
+			tiles = append(tiles, TileCoord{Z: v.zoom, X: wrappedX, Y: y})
+		}
+	}
+	return tiles
+}
+
+// pixelOrigin returns the screen position of the world's top-left pixel at
+// the view's zoom, rounded to whole pixels.
+func (v mapView) pixelOrigin() image.Point {
+	centerX, centerY := latLonToTileXY(v.centerLat, v.centerLon, v.zoom)
+	return image.Pt(int(math.Round(float64(v.width)/2-centerX*mapTileSize)), int(math.Round(float64(v.height)/2-centerY*mapTileSize)))
+}
+
+func (v mapView) tilePosition(coord TileCoord) (float32, float32) {
+	n := math.Pow(2.0, float64(v.zoom))
+	centerPxX := ((v.centerLon + 180.0) / 360.0) * n * mapTileSize
+	centerPxY := (1.0 - math.Log(math.Tan(v.centerLat*math.Pi/180.0)+1.0/math.Cos(v.centerLat*math.Pi/180.0))/math.Pi) / 2.0 * n * mapTileSize
+	tilePxX := float64(coord.X) * mapTileSize
+	tilePxY := float64(coord.Y) * mapTileSize
+	return (v.width / 2.0) + float32(tilePxX-centerPxX), (v.height / 2.0) + float32(tilePxY-centerPxY)
+}
+
+// =====================================================
+// Tile Layer
+// =====================================================
+
+// TileLayer draws raster tiles fetched from a {z}/{x}/{y} URL template.
+type TileLayer struct {
+	id    string
+	store *tileStore
+
+	mu          sync.RWMutex
+	opacity     float32
+	attribution []AttributionLink
+	mapWidget   *TileMapWidget
+
+	// placeholders draws loading and error tiles where tiles are missing.
+	placeholders bool
+
+	// The composite is only touched by the renderer.
+	raster        *canvas.Raster
+	composite     *image.RGBA
+	patterns      *tilePatterns
+	drawnTiles    map[TileCoord]tileDrawState
+	drawnOrigin   image.Point
+	drawnZoom     int
+	fading        map[TileCoord]time.Time
+	fadeAnimation *fyne.Animation
+	retryMarks    []*tileRetryMark
+}
+
+// NewTileLayer creates a raster layer. urlTemplate may contain {z}, {x}, {y}
+// and {size} placeholders. Layers with the same template share their tiles.
+func NewTileLayer(id, urlTemplate string, opacity float32) *TileLayer {
+	return newTileLayer(id, sharedTileStore(urlTemplate, ""), opacity)
+}
+
+func newTileLayer(id string, store *tileStore, opacity float32) *TileLayer {
+	l := &TileLayer{id: id, store: store, opacity: opacity, fading: make(map[TileCoord]time.Time)}
+	l.raster = canvas.NewRaster(func(w, h int) image.Image {
+		if l.composite == nil {
+			return image.Transparent
+		}
+		return l.composite
+	})
+	l.raster.ScaleMode = canvas.ImageScaleFastest
+	return l
+}
+
+func newBaseTileLayer(style MapStyle) *TileLayer {
+	layer := newTileLayer(baseLayerID, style.tileStore(), 1)
+	layer.attribution = mapboxAttribution
+	layer.placeholders = true
+	return layer
+}
+
+func (l *TileLayer) LayerID() string { return l.id }
+
+// attach binds the layer to m. The map subscribes to the layer's store
+// while it is on screen.
+func (l *TileLayer) attach(m *TileMapWidget) {
+	l.mu.Lock()
+	l.mapWidget = m
+	l.mu.Unlock()
+}
+
+func (l *TileLayer) Opacity() float32 {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.opacity
+}
+
+// SetOpacity sets the layer opacity between 0 (invisible) and 1 (opaque).
+func (l *TileLayer) SetOpacity(opacity float32) {
+	l.mu.Lock()
+	l.opacity = float32(math.Max(0, math.Min(1, float64(opacity))))
+	m := l.mapWidget
+	l.mu.Unlock()
+	if m != nil {
+		m.Refresh()
+	}
+}
+
+func (l *TileLayer) Attribution() []AttributionLink {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.attribution
+}
+
+// SetAttribution sets the provider credits shown while the layer is visible.
+func (l *TileLayer) SetAttribution(links ...AttributionLink) {
+	l.mu.Lock()
+	l.attribution = links
+	m := l.mapWidget
+	l.mu.Unlock()
+	if m != nil {
+		m.Refresh()
+	}
+}
+
+// refresh composites the visible tiles into one raster. The composite is kept
+// between frames: a pan scrolls it by whole pixels and only the uncovered
+// strips and tiles whose state changed are drawn. Arriving tiles fade in over
+// their placeholder.
+func (l *TileLayer) refresh(view mapView) []fyne.CanvasObject {
+	bounds := image.Rect(0, 0, int(math.Ceil(float64(view.width))), int(math.Ceil(float64(view.height))))
+	if bounds.Empty() {
+		return nil
+	}
+	origin := view.pixelOrigin()
+	patterns := l.patterns
+	if l.placeholders {
+		patterns = currentTilePatterns(l.patterns)
+	}
+
+	var dirty []image.Rectangle
+	if l.composite == nil || l.composite.Bounds() != bounds || view.zoom != l.drawnZoom || patterns != l.patterns {
+		l.composite = image.NewRGBA(bounds)
+		l.drawnTiles = make(map[TileCoord]tileDrawState)
+		clear(l.fading)
+		dirty = append(dirty, bounds)
+	} else if shift := origin.Sub(l.drawnOrigin); shift != (image.Point{}) {
+		dirty = scrollImage(l.composite, shift)
+		for _, r := range dirty {
+			draw.Draw(l.composite, r, image.Transparent, image.Point{}, draw.Src)
+		}
+	}
+	l.drawnOrigin, l.drawnZoom, l.patterns = origin, view.zoom, patterns
+	changed := len(dirty) > 0
+
+	visible := make(map[TileCoord]bool)
+	var failed []image.Rectangle
+	for _, coord := range view.visibleTiles() {
+		tileRect := image.Rect(0, 0, mapTileSize, mapTileSize).Add(origin.Add(image.Pt(coord.X*mapTileSize, coord.Y*mapTileSize)))
+		r := tileRect.Intersect(bounds)
+		if r.Empty() {
+			continue
+		}
+		visible[coord] = true
+		drawn := l.drawnTiles[coord]
+		fadeStart, fading := l.fading[coord]
+		var targets []image.Rectangle
+		for _, d := range dirty {
+			if overlap := r.Intersect(d); !overlap.Empty() {
+				targets = append(targets, overlap)
+			}
+		}
+		if drawn == tileDrawnImage && !fading && len(targets) == 0 {
+			continue
+		}
+
+		state := tileDrawnPlaceholder
+		imgData, found := l.store.tile(coord)
+		switch {
+		case found:
+			state = tileDrawnImage
+			if drawn == tileDrawnPlaceholder || drawn == tileDrawnError {
+				fadeStart, fading = time.Now(), true
+			}
+		case l.store.failure(coord) != nil:
+			state = tileDrawnError
+			if l.placeholders {
+				failed = append(failed, r)
+			}
+		}
+		if state != drawn || fading {
+			targets = []image.Rectangle{r}
+		}
+		if len(targets) == 0 {
+			continue
+		}
+
+		fade := 1.0
+		if fading {
+			fade = fadeProgress(fadeStart)
+			if fade < 1 {
+				l.fading[coord] = fadeStart
+			} else {
+				delete(l.fading, coord)
+			}
+		}
+		for _, target := range targets {
+			l.drawTileState(target, tileRect, state, imgData, fade)
+		}
+		l.drawnTiles[coord] = state
+		changed = true
+	}
+	for coord := range l.drawnTiles {
+		if !visible[coord] {
+			delete(l.drawnTiles, coord)
+			delete(l.fading, coord)
+		}
+	}
+	if translucency := float64(1 - l.Opacity()); l.raster.Translucency != translucency {
+		l.raster.Translucency = translucency
+		changed = true
+	}
+	l.raster.Resize(fyne.NewSize(float32(bounds.Dx()), float32(bounds.Dy())))
+	l.raster.Move(fyne.NewPos(0, 0))
+	if changed {
+		l.raster.Refresh()
+	}
+
+	l.animateFades()
+
+	objects := []fyne.CanvasObject{l.raster}
+	for i, r := range failed {
+		if i == len(l.retryMarks) {
+			l.retryMarks = append(l.retryMarks, newTileRetryMark())
+		}
+		objects = append(objects, l.retryMarks[i].place(r)...)
+	}
+	return objects
+}
+
+// scrollImage moves the pixels of img by shift and returns the areas left
+// uncovered, which still hold stale pixels.
+func scrollImage(img *image.RGBA, shift image.Point) []image.Rectangle {
+	b := img.Bounds()
+	kept := b.Intersect(b.Add(shift))
+	if kept.Empty() {
+		return []image.Rectangle{b}
+	}
+	rowBytes := kept.Dx() * 4
+	copyRow := func(y int) {
+		dst := img.PixOffset(kept.Min.X, y)
+		src := img.PixOffset(kept.Min.X-shift.X, y-shift.Y)
+		copy(img.Pix[dst:dst+rowBytes], img.Pix[src:src+rowBytes])
+	}
+	if shift.Y > 0 {
+		for y := kept.Max.Y - 1; y >= kept.Min.Y; y-- {
+			copyRow(y)
+		}
+	} else {
+		for y := kept.Min.Y; y < kept.Max.Y; y++ {
+			copyRow(y)
+		}
+	}
+
+	var uncovered []image.Rectangle
+	if kept.Min.Y > b.Min.Y {
+		uncovered = append(uncovered, image.Rect(b.Min.X, b.Min.Y, b.Max.X, kept.Min.Y))
+	}
+	if kept.Max.Y < b.Max.Y {
+		uncovered = append(uncovered, image.Rect(b.Min.X, kept.Max.Y, b.Max.X, b.Max.Y))
+	}
+	if kept.Min.X > b.Min.X {
+		uncovered = append(uncovered, image.Rect(b.Min.X, kept.Min.Y, kept.Min.X, kept.Max.Y))
+	}
+	if kept.Max.X < b.Max.X {
+		uncovered = append(uncovered, image.Rect(kept.Max.X, kept.Min.Y, b.Max.X, kept.Max.Y))
+	}
+	return uncovered
+}
+
+// =====================================================
+// Marker Layer
+// =====================================================
+
+// MarkerLayer draws the widget's markers and the hover tooltip. The marker
+// data itself lives on the widget so that hit testing and selection share it.
+type MarkerLayer struct {
+	mapWidget     *TileMapWidget
+	canvasMarkers map[string]*canvas.Circle
+	tooltip       *markerTooltip
+	userAccuracy  *canvas.Circle
+	userDot       *canvas.Circle
+}
+
+func newMarkerLayer() *MarkerLayer {
+	userAccuracy := canvas.NewCircle(userAccuracyFillColor)
+	userAccuracy.StrokeColor = userLocationColor
+	userAccuracy.StrokeWidth = 1
+	userDot := canvas.NewCircle(userLocationColor)
+	userDot.StrokeColor = color.White
+	userDot.StrokeWidth = 2
+	userDot.Resize(fyne.NewSize(userDotRadius*2, userDotRadius*2))
+	return &MarkerLayer{
+		canvasMarkers: make(map[string]*canvas.Circle),
+		tooltip:       newMarkerTooltip(),
+		userAccuracy:  userAccuracy,
+		userDot:       userDot,
+	}
+}
+
+func (l *MarkerLayer) LayerID() string { return markerLayerID }
+
+func (l *MarkerLayer) attach(m *TileMapWidget) { l.mapWidget = m }
+
+func (l *MarkerLayer) refresh(view mapView) []fyne.CanvasObject {
+	m := l.mapWidget
+	m.mu.RLock()
+	currentMarkers := make([]*MapMarker, len(m.markers))
+	copy(currentMarkers, m.markers)
+	hoveredID := m.hoveredID
+	selectedID := m.selectedID
+	userPos, userAccuracy := m.userPos, m.userAccuracy
+	m.mu.RUnlock()
+
+	objects := make([]fyne.CanvasObject, 0, len(currentMarkers)+2)
+	activeCanvasMarkers := make(map[string]bool)
+	var hoveredMarker *MapMarker
+
+	if userPos != nil {
+		x, y := view.project(userPos.Lat, userPos.Lon)
+		if radius := float32(userAccuracy / metersPerPixelAt(userPos.Lat, view.zoom)); radius > userDotRadius {
+			l.userAccuracy.Resize(fyne.NewSize(radius*2, radius*2))
+			l.userAccuracy.Move(fyne.NewPos(x-radius, y-radius))
+			objects = append(objects, l.userAccuracy)
+		}
+		l.userDot.Move(fyne.NewPos(x-userDotRadius, y-userDotRadius))
+		objects = append(objects, l.userDot)
+	}
+
+	for _, marker := range currentMarkers {
+		screenX, screenY := view.project(marker.Lat, marker.Lon)
+		if screenX < 0 || screenY < 0 {
+			continue
+		}
+
+		circle, exists := l.canvasMarkers[marker.ID]
+		if !exists {
+			circle = canvas.NewCircle(mapMarkerColor)
+			circle.Resize(fyne.NewSize(markerRadius*2, markerRadius*2))
+			l.canvasMarkers[marker.ID] = circle
+		}
+
+		markerColor := mapMarkerColor
+		if marker.ID == selectedID {
+			markerColor = mapSelectedMarkerColor
+		}
+		if circle.FillColor != markerColor {
+			circle.FillColor = markerColor
+			circle.Refresh()
+		}
+		circle.Move(fyne.NewPos(screenX-markerRadius, screenY-markerRadius))
+		circle.Show()
+
+		objects = append(objects, circle)
+		activeCanvasMarkers[marker.ID] = true
+		if marker.ID == hoveredID {
+			hoveredMarker = marker
+		}
+	}
+
+	for id, circle := range l.canvasMarkers {
+		if !activeCanvasMarkers[id] {
+			circle.Hide()
+			delete(l.canvasMarkers, id)
+		}
+	}
+
+	if hoveredMarker != nil {
+		screenX, screenY := view.project(hoveredMarker.Lat, hoveredMarker.Lon)
+		objects = append(objects, l.tooltip.update(hoveredMarker, screenX, screenY, view.width, view.height)...)
+	}
+	return objects
+}
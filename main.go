@@ -1,7 +1,7 @@
 package main
 
 import (
-	"context"
+	_ "embed"
 	"fmt"
 	"image"
 	"image/color"
@@ -22,13 +22,46 @@ import (
 	"fyne.io/fyne/v2/canvas"
 	"fyne.io/fyne/v2/container"
 	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/driver/desktop"
 	"fyne.io/fyne/v2/layout"
+	"fyne.io/fyne/v2/storage"
 	"fyne.io/fyne/v2/theme"
 	"fyne.io/fyne/v2/widget"
 )
 
 var mainWindow fyne.Window
 
+// regionsGeoJSON outlines office regions and data-residency zones on the gateway map.
+//
+//go:embed regions.geojson
+var regionsGeoJSON []byte
+
+// deviceLocation is where the local GeoIP database places this device, or nil if unknown.
+var deviceLocation *GeoIPLocation
+
+// latencySamples are client-reported latencies to the nearest gateway, shown as the map heatmap.
+var latencySamples = []LatencySample{
+	{Pos: LatLng{Lat: 10.8231, Lon: 106.6297}, Latency: 12 * time.Millisecond},
+	{Pos: LatLng{Lat: 21.0278, Lon: 105.8342}, Latency: 31 * time.Millisecond},
+	{Pos: LatLng{Lat: 13.7563, Lon: 100.5018}, Latency: 15 * time.Millisecond},
+	{Pos: LatLng{Lat: 11.5564, Lon: 104.9282}, Latency: 22 * time.Millisecond},
+	{Pos: LatLng{Lat: 1.3521, Lon: 103.8198}, Latency: 38 * time.Millisecond},
+	{Pos: LatLng{Lat: 3.1390, Lon: 101.6869}, Latency: 41 * time.Millisecond},
+	{Pos: LatLng{Lat: -6.2088, Lon: 106.8456}, Latency: 64 * time.Millisecond},
+	{Pos: LatLng{Lat: 14.5995, Lon: 120.9842}, Latency: 72 * time.Millisecond},
+	{Pos: LatLng{Lat: 22.3193, Lon: 114.1694}, Latency: 58 * time.Millisecond},
+	{Pos: LatLng{Lat: 16.8409, Lon: 96.1735}, Latency: 47 * time.Millisecond},
+	{Pos: LatLng{Lat: 19.0760, Lon: 72.8777}, Latency: 118 * time.Millisecond},
+	{Pos: LatLng{Lat: 25.2048, Lon: 55.2708}, Latency: 96 * time.Millisecond},
+	{Pos: LatLng{Lat: 50.1109, Lon: 8.6821}, Latency: 9 * time.Millisecond},
+	{Pos: LatLng{Lat: 52.5200, Lon: 13.4050}, Latency: 14 * time.Millisecond},
+	{Pos: LatLng{Lat: 48.8566, Lon: 2.3522}, Latency: 19 * time.Millisecond},
+	{Pos: LatLng{Lat: 51.5074, Lon: -0.1278}, Latency: 24 * time.Millisecond},
+	{Pos: LatLng{Lat: 41.9028, Lon: 12.4964}, Latency: 33 * time.Millisecond},
+	{Pos: LatLng{Lat: 40.4168, Lon: -3.7038}, Latency: 39 * time.Millisecond},
+	{Pos: LatLng{Lat: 41.0082, Lon: 28.9784}, Latency: 52 * time.Millisecond},
+}
+
 // =====================================================
 // Map Widget Code
 // =====================================================
@@ -38,12 +71,12 @@ const (
 	minZoom         = 7
 	maxZoom         = 7
 	mapTileSize     = 256
-	tileResultBuf   = 64
 	yourUserAgent   = "MyFyneMapApp/0.4 (contact@example.com)"
 	markerRadius    = 5
+	userDotRadius   = 7
 	markerHitRadius = 10.0
+	tooltipOffset   = 12
 	fetchTimeout    = 15 * time.Second
-	sendTimeout     = 5 * time.Second
 
 	mapboxUsername    = "thanhdat19"
 	mapboxStyleID     = "cm9is30la00sx01qua6xa2b7s"
@@ -51,157 +84,377 @@ const (
 )
 
 var (
-	mapMarkerColor  = color.NRGBA{R: 0, G: 0, B: 255, A: 255}
-	httpClient      = &http.Client{Timeout: fetchTimeout}
-	tileCachePath   string
-	cacheWriteMutex sync.Mutex
+	mapMarkerColor         = color.NRGBA{R: 0, G: 0, B: 255, A: 255}
+	mapSelectedMarkerColor = color.NRGBA{R: 255, G: 140, B: 0, A: 255}
+	userLocationColor      = color.NRGBA{R: 0, G: 170, B: 255, A: 255}
+	userAccuracyFillColor  = color.NRGBA{R: 0, G: 170, B: 255, A: 50}
+	httpClient             = &http.Client{Timeout: fetchTimeout}
+	tileCachePath          string
+	cacheWriteMutex        sync.Mutex
 )
 
 type TileCoord struct {
 	Z, X, Y int
 }
 
-type TileResult struct {
-	Coord TileCoord
-	Image image.Image
-	Error error
+type LatLng struct {
+	Lat, Lon float64
 }
 
 type MapMarker struct {
-	ID   string
-	Lat  float64
-	Lon  float64
-	Name string
+	ID      string
+	Lat     float64
+	Lon     float64
+	Name    string
+	Region  string
+	Latency time.Duration
+	// LatencyPlaceholder marks Latency as a stand-in rather than a
+	// measurement; the tooltip says so.
+	LatencyPlaceholder bool
 }
 
 type TileMapWidget struct {
 	widget.BaseWidget
 	mu sync.RWMutex
 
-	zoom           int
-	centerLat      float64
-	centerLon      float64
-	width          float32
-	height         float32
-	imageDataCache map[TileCoord]image.Image
-	tileFetching   map[TileCoord]bool
-	resultChan     chan TileResult
-	stopChan       chan struct{}
-	markers        []*MapMarker
-	parentWindow   fyne.Window
+	zoom         int
+	zoomMin      int
+	zoomMax      int
+	centerLat    float64
+	centerLon    float64
+	width        float32
+	height       float32
+	layers       []MapLayer
+	hiddenLayers map[string]bool
+	baseLayer    *TileLayer
+	style        MapStyle
+	chosenStyle  MapStyle
+	lightStyle   MapStyle
+	darkStyle    MapStyle
+	styleSource  *TileMapWidget
+	vectorLayer  *VectorLayer
+	markerLayer  *MarkerLayer
+	debugOverlay *debugOverlay
+	markers      []*MapMarker
+	userPos      *LatLng
+	userAccuracy float64
+	scaleUnits   ScaleUnits
+	home         *mapView
+	locateID     string
+	hoveredID    string
+	selectedID   string
+	pendingFit   *pendingFit
+	flight       *fyne.Animation
+	flightDone   func()
+	viewWatchers []func(view mapView)
+	tileHandlers []*tileEventHandler
+	// subscribed is set while the map has a renderer and receives the tile
+	// events of its tile layers' stores.
+	subscribed bool
+
+	// rendered is set once the widget has a renderer; until then there is
+	// nothing on screen for tile results to update.
+	rendered      atomic.Bool
+	refreshQueued atomic.Bool
+
+	// OnMarkerTapped is called when a marker is tapped.
+	OnMarkerTapped func(marker *MapMarker)
+	// OnMarkerHovered is called when the pointer enters a marker, and with nil when it leaves.
+	OnMarkerHovered func(marker *MapMarker)
+	// OnMapTapped is called when the map is tapped away from any marker.
+	OnMapTapped func(pos LatLng)
+	// OnDragged, if set, receives drag events instead of the map panning itself.
+	OnDragged func(e *fyne.DragEvent)
 }
 
-func NewTileMapWidget(startZoom int, startLat, startLon float64, parentWin fyne.Window) *TileMapWidget {
+func NewTileMapWidget(startZoom int, startLat, startLon float64) *TileMapWidget {
 	m := &TileMapWidget{
-		zoom:           startZoom,
-		centerLat:      startLat,
-		centerLon:      startLon,
-		imageDataCache: make(map[TileCoord]image.Image),
-		tileFetching:   make(map[TileCoord]bool),
-		resultChan:     make(chan TileResult, tileResultBuf),
-		stopChan:       make(chan struct{}),
-		markers:        make([]*MapMarker, 0),
-		parentWindow:   parentWin,
+		zoom:         startZoom,
+		zoomMin:      minZoom,
+		zoomMax:      maxZoom,
+		centerLat:    startLat,
+		centerLon:    startLon,
+		hiddenLayers: map[string]bool{debugLayerID: true},
+		baseLayer:    newBaseTileLayer(mapboxDarkStyle),
+		style:        mapboxDarkStyle,
+		lightStyle:   mapboxLightStyle,
+		darkStyle:    mapboxDarkStyle,
+		vectorLayer:  NewVectorLayer(vectorLayerID),
+		markerLayer:  newMarkerLayer(),
+		debugOverlay: newDebugOverlay(),
+		markers:      make([]*MapMarker, 0),
 	}
 	m.ExtendBaseWidget(m)
-	go m.processTileResultsLoop()
+	for _, layer := range []MapLayer{m.baseLayer, m.vectorLayer, m.markerLayer, newScaleBarOverlay(), newAttributionOverlay(), newMapControlsOverlay(), m.debugOverlay} {
+		layer.attach(m)
+		m.layers = append(m.layers, layer)
+	}
 	return m
 }
 
-func (m *TileMapWidget) processTileResultsLoop() {
-	for {
-		select {
-		case result, ok := <-m.resultChan:
-			if !ok {
+// handleTileEvent receives the events of the map's tile stores, on the
+// goroutine that produced them. Redraws are handed to the UI thread.
+func (m *TileMapWidget) handleTileEvent(ev TileEvent) {
+	m.debugOverlay.record(ev)
+	if ev.Kind == TileFailed {
+		log.Printf("Error fetching tile %v: %v", ev.Coord, ev.Err)
+	}
+	if ev.Kind != TileRequested {
+		m.queueRefresh()
+	}
+	m.mu.RLock()
+	handlers := append([]*tileEventHandler(nil), m.tileHandlers...)
+	m.mu.RUnlock()
+	for _, h := range handlers {
+		h.fn(ev)
+	}
+}
+
+type tileEventHandler struct {
+	fn func(e TileEvent)
+}
+
+// SubscribeTileEvents calls fn with every event of the map's tile layers
+// until the returned function is called. fn runs on the goroutine that
+// produced the event, not the UI thread, and must not block; use fyne.Do to
+// update widgets from it.
+func (m *TileMapWidget) SubscribeTileEvents(fn func(e TileEvent)) (unsubscribe func()) {
+	h := &tileEventHandler{fn: fn}
+	m.mu.Lock()
+	m.tileHandlers = append(m.tileHandlers, h)
+	m.mu.Unlock()
+	return func() {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		for i, other := range m.tileHandlers {
+			if other == h {
+				m.tileHandlers = append(m.tileHandlers[:i:i], m.tileHandlers[i+1:]...)
 				return
 			}
-			m.handleTileResult(result)
-			m.Refresh()
-		case <-m.stopChan:
-			return
 		}
 	}
 }
 
-func (m *TileMapWidget) handleTileResult(result TileResult) {
+// queueRefresh schedules a redraw on the UI thread from a background
+// goroutine. Requests arriving before the redraw runs are merged into it.
+func (m *TileMapWidget) queueRefresh() {
+	if !m.rendered.Load() || !m.refreshQueued.CompareAndSwap(false, true) {
+		return
+	}
+	fyne.Do(func() {
+		m.refreshQueued.Store(false)
+		m.Refresh()
+	})
+}
+
+// SetUserLocation shows the "you are here" marker at pos, surrounded by a
+// circle of accuracyMeters radius.
+func (m *TileMapWidget) SetUserLocation(pos LatLng, accuracyMeters float64) {
 	m.mu.Lock()
-	defer m.mu.Unlock()
+	m.userPos = &pos
+	m.userAccuracy = accuracyMeters
+	m.mu.Unlock()
+	m.Refresh()
+}
 
-	delete(m.tileFetching, result.Coord)
+func (m *TileMapWidget) ClearUserLocation() {
+	m.mu.Lock()
+	m.userPos = nil
+	m.mu.Unlock()
+	m.Refresh()
+}
 
-	if result.Error == nil && result.Image != nil {
-		if result.Image.Bounds().Dx() > 0 && result.Image.Bounds().Dy() > 0 {
-			m.imageDataCache[result.Coord] = result.Image
+// AddMarkers adds markers to the map, replacing any existing marker with the same ID.
+func (m *TileMapWidget) AddMarkers(newMarkers ...*MapMarker) {
+	validMarkers := validMapMarkers(newMarkers)
+	if len(validMarkers) == 0 {
+		return
+	}
+	m.mu.Lock()
+	for _, marker := range validMarkers {
+		if i := m.markerIndex(marker.ID); i >= 0 {
+			m.markers[i] = marker
 		} else {
-			log.Printf("Warning: Received invalid image for tile %v (zero dimensions)", result.Coord)
+			m.markers = append(m.markers, marker)
 		}
-	} else if result.Error != nil {
-		if result.Error.Error() != "tile not found (404)" {
-			log.Printf("Error fetching tile %v: %v", result.Coord, result.Error)
+	}
+	m.mu.Unlock()
+	m.Refresh()
+}
+
+// SetMarkers replaces all markers on the map.
+func (m *TileMapWidget) SetMarkers(newMarkers ...*MapMarker) {
+	validMarkers := validMapMarkers(newMarkers)
+	m.mu.Lock()
+	m.markers = make([]*MapMarker, 0, len(validMarkers))
+	for _, marker := range validMarkers {
+		if i := m.markerIndex(marker.ID); i >= 0 {
+			m.markers[i] = marker
+		} else {
+			m.markers = append(m.markers, marker)
 		}
-	} else {
-		log.Printf("Warning: Received nil image and nil error for tile %v", result.Coord)
 	}
+	m.resetStaleMarkerState()
+	m.mu.Unlock()
+	m.Refresh()
 }
 
-func (m *TileMapWidget) AddMarkers(newMarkers ...*MapMarker) {
-	if len(newMarkers) == 0 {
-		return
+// UpdateMarker replaces the marker with the same ID, returning false if there is none.
+func (m *TileMapWidget) UpdateMarker(marker *MapMarker) bool {
+	if marker == nil || marker.ID == "" {
+		return false
 	}
-	validMarkers := make([]*MapMarker, 0, len(newMarkers))
-	for _, marker := range newMarkers {
-		if marker != nil {
-			validMarkers = append(validMarkers, marker)
-		}
+	m.mu.Lock()
+	i := m.markerIndex(marker.ID)
+	if i >= 0 {
+		m.markers[i] = marker
 	}
-	if len(validMarkers) == 0 {
-		return
+	m.mu.Unlock()
+	if i < 0 {
+		return false
 	}
+	m.Refresh()
+	return true
+}
+
+// RemoveMarker removes the marker with the given ID, returning false if there is none.
+func (m *TileMapWidget) RemoveMarker(id string) bool {
 	m.mu.Lock()
-	m.markers = append(m.markers, validMarkers...)
+	i := m.markerIndex(id)
+	if i >= 0 {
+		m.markers = append(m.markers[:i], m.markers[i+1:]...)
+		m.resetStaleMarkerState()
+	}
 	m.mu.Unlock()
+	if i < 0 {
+		return false
+	}
 	m.Refresh()
+	return true
 }
 
-func (m *TileMapWidget) latLonToScreenXY(markerLat, markerLon float64) (float32, float32) {
+func (m *TileMapWidget) ClearMarkers() {
+	m.mu.Lock()
+	m.markers = make([]*MapMarker, 0)
+	m.resetStaleMarkerState()
+	m.mu.Unlock()
+	m.Refresh()
+}
+
+// Marker returns the marker with the given ID, or nil.
+func (m *TileMapWidget) Marker(id string) *MapMarker {
 	m.mu.RLock()
-	zoom := m.zoom
-	centerLat := m.centerLat
-	centerLon := m.centerLon
-	w := m.width
-	h := m.height
-	m.mu.RUnlock()
+	defer m.mu.RUnlock()
+	if i := m.markerIndex(id); i >= 0 {
+		return m.markers[i]
+	}
+	return nil
+}
+
+// markerIndex must be called with m.mu held.
+func (m *TileMapWidget) markerIndex(id string) int {
+	for i, marker := range m.markers {
+		if marker.ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// resetStaleMarkerState drops hover and selection pointing at removed markers.
+// It must be called with m.mu held.
+func (m *TileMapWidget) resetStaleMarkerState() {
+	if m.markerIndex(m.hoveredID) < 0 {
+		m.hoveredID = ""
+	}
+	if m.markerIndex(m.selectedID) < 0 {
+		m.selectedID = ""
+	}
+}
 
-	if w <= 0 || h <= 0 {
+func validMapMarkers(markers []*MapMarker) []*MapMarker {
+	validMarkers := make([]*MapMarker, 0, len(markers))
+	for _, marker := range markers {
+		if marker == nil {
+			continue
+		}
+		if marker.ID == "" {
+			log.Printf("Warning: Ignoring marker %q without ID", marker.Name)
+			continue
+		}
+		validMarkers = append(validMarkers, marker)
+	}
+	return validMarkers
+}
+
+func (m *TileMapWidget) latLonToScreenXY(markerLat, markerLon float64) (float32, float32) {
+	view := m.currentView()
+	if view.width <= 0 || view.height <= 0 {
 		return -1, -1
 	}
-	n := math.Pow(2.0, float64(zoom))
-	markerPxX := ((markerLon + 180.0) / 360.0) * n * mapTileSize
-	markerPxY := (1.0 - math.Log(math.Tan(markerLat*math.Pi/180.0)+1.0/math.Cos(markerLat*math.Pi/180.0))/math.Pi) / 2.0 * n * mapTileSize
-	centerPxX := ((centerLon + 180.0) / 360.0) * n * mapTileSize
-	centerPxY := (1.0 - math.Log(math.Tan(centerLat*math.Pi/180.0)+1.0/math.Cos(centerLat*math.Pi/180.0))/math.Pi) / 2.0 * n * mapTileSize
-	offsetX := markerPxX - centerPxX
-	offsetY := markerPxY - centerPxY
-	screenX := (w / 2.0) + float32(offsetX)
-	screenY := (h / 2.0) + float32(offsetY)
-	return screenX, screenY
+	return view.project(markerLat, markerLon)
+}
+
+func (m *TileMapWidget) screenXYToLatLon(screenX, screenY float32) LatLng {
+	return m.currentView().unproject(screenX, screenY)
 }
 
 func (m *TileMapWidget) CreateRenderer() fyne.WidgetRenderer {
-	r := &tileMapRenderer{
-		mapWidget:     m,
-		canvasTiles:   make(map[TileCoord]*canvas.Image),
-		canvasMarkers: make(map[*MapMarker]fyne.CanvasObject),
+	m.mu.Lock()
+	if !m.subscribed {
+		m.subscribed = true
+		for _, layer := range m.layers {
+			if tiles, ok := layer.(*TileLayer); ok {
+				tiles.store.subscribe(m)
+			}
+		}
 	}
+	m.mu.Unlock()
+	r := &tileMapRenderer{mapWidget: m}
 	r.Refresh()
+	m.rendered.Store(true)
 	return r
 }
 
+// Destroy stops the map receiving tile events. Fyne calls it through the
+// renderer once the map has left the screen; a new renderer subscribes again.
+// A base style no other map draws is dropped from memory, as in syncMapStyle.
 func (m *TileMapWidget) Destroy() {
-	close(m.stopChan)
+	m.rendered.Store(false)
+	m.mu.Lock()
+	if !m.subscribed {
+		m.mu.Unlock()
+		return
+	}
+	m.subscribed = false
+	var unused *tileStore
+	for _, layer := range m.layers {
+		tiles, ok := layer.(*TileLayer)
+		if !ok {
+			continue
+		}
+		tiles.stopFades()
+		if tiles.store.unsubscribe(m) == 0 && tiles == m.baseLayer {
+			unused = tiles.store
+		}
+	}
+	style, source := m.style, m.styleSource
+	m.mu.Unlock()
+
+	if source == nil {
+		source = m
+	}
+	if unused != nil && !source.isThemeStyle(style) {
+		unused.release()
+	}
 }
 
 func (m *TileMapWidget) Dragged(e *fyne.DragEvent) {
+	if m.OnDragged != nil {
+		m.OnDragged(e)
+		return
+	}
+	m.stopFlight()
 	m.mu.RLock()
 	currentZoom := m.zoom
 	currentLat := m.centerLat
@@ -236,19 +489,27 @@ func (m *TileMapWidget) Scrolled(e *fyne.ScrollEvent) {
 	if dy == 0 {
 		return
 	}
-	m.mu.Lock()
-	zoomChanged := false
 	if dy < 0 {
-		if m.zoom < maxZoom {
-			m.zoom++
-			zoomChanged = true
-		}
+		m.ZoomIn()
 	} else {
-		if m.zoom > minZoom {
-			m.zoom--
-			zoomChanged = true
-		}
+		m.ZoomOut()
 	}
+}
+
+func (m *TileMapWidget) ZoomIn() {
+	m.zoomBy(1)
+}
+
+func (m *TileMapWidget) ZoomOut() {
+	m.zoomBy(-1)
+}
+
+func (m *TileMapWidget) zoomBy(delta int) {
+	m.stopFlight()
+	m.mu.Lock()
+	newZoom := m.clampZoomLocked(m.zoom + delta)
+	zoomChanged := newZoom != m.zoom
+	m.zoom = newZoom
 	m.mu.Unlock()
 	if zoomChanged {
 		m.Refresh()
@@ -256,26 +517,92 @@ func (m *TileMapWidget) Scrolled(e *fyne.ScrollEvent) {
 }
 
 func (m *TileMapWidget) Tapped(e *fyne.PointEvent) {
+	marker := m.markerAt(e.Position)
+	if marker == nil {
+		if m.retryTileAt(e.Position) {
+			return
+		}
+		if m.OnMapTapped != nil {
+			m.OnMapTapped(m.screenXYToLatLon(e.Position.X, e.Position.Y))
+		}
+		return
+	}
+	log.Printf("Tapped Marker: %s (%.4f, %.4f)", marker.Name, marker.Lat, marker.Lon)
+	if m.OnMarkerTapped != nil {
+		m.OnMarkerTapped(marker)
+	}
+}
+
+// SelectMarker highlights the marker with the given ID; an empty ID clears the selection.
+func (m *TileMapWidget) SelectMarker(id string) {
+	m.mu.Lock()
+	changed := m.selectedID != id
+	m.selectedID = id
+	m.mu.Unlock()
+	if changed {
+		m.Refresh()
+	}
+}
+
+func (m *TileMapWidget) SelectedMarkerID() string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.selectedID
+}
+
+func (m *TileMapWidget) markerAt(pos fyne.Position) *MapMarker {
 	m.mu.RLock()
 	markersToCheck := make([]*MapMarker, len(m.markers))
 	copy(markersToCheck, m.markers)
 	m.mu.RUnlock()
 	for _, marker := range markersToCheck {
 		markerX, markerY := m.latLonToScreenXY(marker.Lat, marker.Lon)
-		dx := e.Position.X - markerX
-		dy := e.Position.Y - markerY
+		dx := pos.X - markerX
+		dy := pos.Y - markerY
 		distSq := dx*dx + dy*dy
 		if distSq <= (markerHitRadius * markerHitRadius) {
-			log.Printf("Tapped Marker: %s (%.4f, %.4f)", marker.Name, marker.Lat, marker.Lon)
-			if m.parentWindow != nil {
-				info := fmt.Sprintf("Marker: %s\nLat: %.6f\nLon: %.6f", marker.Name, marker.Lat, marker.Lon)
-				dialog.ShowInformation("Marker Info", info, m.parentWindow)
-			} else {
-				log.Println("Warning: Cannot show marker dialog, parent window reference is nil")
-			}
-			return
+			return marker
 		}
 	}
+	return nil
+}
+
+func (m *TileMapWidget) MouseIn(e *desktop.MouseEvent) {
+	m.setHoveredMarker(m.markerAt(e.Position))
+}
+
+func (m *TileMapWidget) MouseMoved(e *desktop.MouseEvent) {
+	m.setHoveredMarker(m.markerAt(e.Position))
+}
+
+func (m *TileMapWidget) MouseOut() {
+	m.setHoveredMarker(nil)
+}
+
+func (m *TileMapWidget) Cursor() desktop.Cursor {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if m.hoveredID != "" {
+		return desktop.PointerCursor
+	}
+	return desktop.DefaultCursor
+}
+
+func (m *TileMapWidget) setHoveredMarker(marker *MapMarker) {
+	m.mu.Lock()
+	hoveredID := ""
+	if marker != nil {
+		hoveredID = marker.ID
+	}
+	changed := m.hoveredID != hoveredID
+	m.hoveredID = hoveredID
+	m.mu.Unlock()
+	if changed {
+		if m.OnMarkerHovered != nil {
+			m.OnMarkerHovered(marker)
+		}
+		m.Refresh()
+	}
 }
 
 func (m *TileMapWidget) clampView() {
@@ -289,181 +616,135 @@ func (m *TileMapWidget) clampView() {
 }
 
 type tileMapRenderer struct {
-	mapWidget     *TileMapWidget
-	objects       []fyne.CanvasObject
-	canvasTiles   map[TileCoord]*canvas.Image
-	canvasMarkers map[*MapMarker]fyne.CanvasObject
+	mapWidget *TileMapWidget
+	objects   []fyne.CanvasObject
+	lastView  mapView
 }
 
-func (r *tileMapRenderer) Layout(size fyne.Size) {
-	r.mapWidget.mu.Lock()
-	r.mapWidget.width = size.Width
-	r.mapWidget.height = size.Height
-	r.mapWidget.mu.Unlock()
+// markerTooltip is the lightweight hover label drawn next to a marker.
+type markerTooltip struct {
+	background *canvas.Rectangle
+	lines      []*canvas.Text
 }
 
-func (r *tileMapRenderer) MinSize() fyne.Size {
-	return fyne.NewSize(mapTileSize, mapTileSize)
+func newMarkerTooltip() *markerTooltip {
+	t := &markerTooltip{background: canvas.NewRectangle(theme.OverlayBackgroundColor())}
+	t.background.CornerRadius = theme.InputRadiusSize()
+	t.background.StrokeColor = theme.DisabledColor()
+	t.background.StrokeWidth = 1
+	for i := 0; i < 3; i++ {
+		line := canvas.NewText("", theme.ForegroundColor())
+		line.TextSize = theme.CaptionTextSize()
+		t.lines = append(t.lines, line)
+	}
+	t.lines[0].TextStyle = fyne.TextStyle{Bold: true}
+	return t
 }
 
-func (r *tileMapRenderer) Refresh() {
-	r.processTileResults()
-
-	r.mapWidget.mu.RLock()
-	zoom, centerLat, centerLon := r.mapWidget.zoom, r.mapWidget.centerLat, r.mapWidget.centerLon
-	width, height := r.mapWidget.width, r.mapWidget.height
-	currentMarkers := make([]*MapMarker, len(r.mapWidget.markers))
-	copy(currentMarkers, r.mapWidget.markers)
-	r.mapWidget.mu.RUnlock()
-
-	if width <= 0 || height <= 0 {
-		return
+// update fills the tooltip for marker, anchors it next to the marker's screen
+// position (flipping sides near the widget edges) and returns its objects.
+func (t *markerTooltip) update(marker *MapMarker, markerX, markerY, width, height float32) []fyne.CanvasObject {
+	texts := []string{marker.Name, marker.Region}
+	if marker.Latency > 0 && marker.LatencyPlaceholder {
+		texts = append(texts, fmt.Sprintf("Latency: %d ms (placeholder)", marker.Latency.Milliseconds()))
+	} else if marker.Latency > 0 {
+		texts = append(texts, fmt.Sprintf("Latency: %d ms", marker.Latency.Milliseconds()))
 	}
 
-	visibleTiles := r.calculateRequiredTiles(zoom, centerLat, centerLon, width, height)
-	neededCoords := make([]TileCoord, 0, len(visibleTiles))
-	activeCanvasTiles := make(map[TileCoord]bool)
-	currentTileObjects := make([]fyne.CanvasObject, 0, len(visibleTiles))
-
-	r.mapWidget.mu.Lock()
-	for _, coord := range visibleTiles {
-		imgData, dataFound := r.mapWidget.imageDataCache[coord]
-
-		if !dataFound && tileCachePath != "" {
-			tileFilePath := getTileFilePath(coord)
-			cachedImg, err := readTileFromCache(tileFilePath)
-			if err == nil && cachedImg != nil {
-
-				imgData = cachedImg
-				r.mapWidget.imageDataCache[coord] = imgData
-				dataFound = true
-			} else if err != nil && !os.IsNotExist(err) {
-
-				log.Printf("Warning: Error reading tile cache file %s: %v", tileFilePath, err)
-
-			}
-
+	padding := theme.Padding()
+	objs := []fyne.CanvasObject{t.background}
+	contentW, contentH := float32(0), float32(0)
+	for i, line := range t.lines {
+		if i >= len(texts) || texts[i] == "" {
+			line.Hide()
+			continue
 		}
-
-		if dataFound {
-			canvasImg, canvasFound := r.canvasTiles[coord]
-			if !canvasFound {
-				canvasImg = canvas.NewImageFromImage(imgData)
-				if canvasImg == nil {
-					log.Printf("Error: Failed canvas image creation tile %v", coord)
-					delete(r.mapWidget.imageDataCache, coord)
-
-					if tileCachePath != "" {
-
-					}
-					continue
-				}
-				canvasImg.ScaleMode, canvasImg.FillMode = canvas.ImageScaleFastest, canvas.ImageFillOriginal
-				canvasImg.Resize(fyne.NewSize(mapTileSize, mapTileSize))
-				r.canvasTiles[coord] = canvasImg
-			}
-
-			posX, posY := r.calculateTilePosition(coord, zoom, centerLat, centerLon, width, height)
-			canvasImg.Move(fyne.NewPos(posX, posY))
-			canvasImg.Show()
-			currentTileObjects = append(currentTileObjects, canvasImg)
-			activeCanvasTiles[coord] = true
-		} else {
-			if !r.mapWidget.tileFetching[coord] {
-				neededCoords = append(neededCoords, coord)
-				r.mapWidget.tileFetching[coord] = true
-			}
+		line.Text = texts[i]
+		line.Color = theme.ForegroundColor()
+		line.Refresh()
+		size := line.MinSize()
+		line.Resize(size)
+		line.Move(fyne.NewPos(padding, padding+contentH))
+		line.Show()
+		contentH += size.Height
+		if size.Width > contentW {
+			contentW = size.Width
 		}
+		objs = append(objs, line)
 	}
-	r.mapWidget.mu.Unlock()
 
-	for coord, img := range r.canvasTiles {
-		if !activeCanvasTiles[coord] {
-			img.Hide()
-			delete(r.canvasTiles, coord)
-		}
+	boxW, boxH := contentW+padding*2, contentH+padding*2
+	x, y := markerX+tooltipOffset, markerY-boxH-tooltipOffset
+	if x+boxW > width {
+		x = markerX - tooltipOffset - boxW
 	}
+	if y < 0 {
+		y = markerY + tooltipOffset
+	}
+	t.background.FillColor = theme.OverlayBackgroundColor()
+	t.background.Resize(fyne.NewSize(boxW, boxH))
+	t.background.Move(fyne.NewPos(x, y))
+	t.background.Refresh()
+	for _, line := range objs[1:] {
+		line.Move(line.Position().Add(fyne.NewPos(x, y)))
+	}
+	return objs
+}
 
-	currentMarkerObjects := make([]fyne.CanvasObject, 0, len(currentMarkers))
-	activeCanvasMarkers := make(map[*MapMarker]bool)
-
-	for _, marker := range currentMarkers {
-		screenX, screenY := r.mapWidget.latLonToScreenXY(marker.Lat, marker.Lon)
-		if screenX < 0 || screenY < 0 {
-			continue
-		}
-
-		canvasObj, exists := r.canvasMarkers[marker]
-		var circle *canvas.Circle
-
-		if !exists {
-
-			circle = canvas.NewCircle(mapMarkerColor)
-			circle.Resize(fyne.NewSize(markerRadius*2, markerRadius*2))
-			r.canvasMarkers[marker] = circle
-			canvasObj = circle
-		} else {
-
-			var ok bool
-			circle, ok = canvasObj.(*canvas.Circle)
-			if !ok {
-				log.Printf("Error: Expected *canvas.Circle for marker %s, got %T. Recreating.", marker.Name, canvasObj)
-				delete(r.canvasMarkers, marker)
-				continue
-			}
-
-		}
+func (r *tileMapRenderer) Layout(size fyne.Size) {
+	r.mapWidget.mu.Lock()
+	resized := r.mapWidget.width != size.Width || r.mapWidget.height != size.Height
+	r.mapWidget.width = size.Width
+	r.mapWidget.height = size.Height
+	r.mapWidget.mu.Unlock()
+	r.mapWidget.applyPendingFit()
+	r.mapWidget.recordHomeView()
+	if resized {
+		r.Refresh()
+	}
+}
 
-		circle.Move(fyne.NewPos(screenX-markerRadius, screenY-markerRadius))
-		circle.Show()
+func (r *tileMapRenderer) MinSize() fyne.Size {
+	return fyne.NewSize(mapTileSize, mapTileSize)
+}
 
-		currentMarkerObjects = append(currentMarkerObjects, canvasObj)
-		activeCanvasMarkers[marker] = true
+// Refresh runs on the UI thread, like every other renderer method, so the
+// renderer and layer canvas objects need no locking of their own.
+func (r *tileMapRenderer) Refresh() {
+	styleChanged := r.mapWidget.syncMapStyle()
+	view := r.mapWidget.currentView()
+	if view.width <= 0 || view.height <= 0 {
+		return
 	}
 
-	for marker, obj := range r.canvasMarkers {
-		if !activeCanvasMarkers[marker] {
-			obj.Hide()
-			delete(r.canvasMarkers, marker)
+	r.mapWidget.mu.RLock()
+	watchers := r.mapWidget.viewWatchers
+	layers := make([]MapLayer, 0, len(r.mapWidget.layers))
+	var hiddenTiles []*TileLayer
+	for _, layer := range r.mapWidget.layers {
+		if !r.mapWidget.hiddenLayers[layer.LayerID()] {
+			layers = append(layers, layer)
+		} else if tiles, ok := layer.(*TileLayer); ok {
+			hiddenTiles = append(hiddenTiles, tiles)
 		}
 	}
+	r.mapWidget.mu.RUnlock()
 
-	r.objects = append(currentTileObjects, currentMarkerObjects...)
-
-	for _, coord := range neededCoords {
-		go r.fetchTileDataAsync(coord)
+	for _, tiles := range hiddenTiles {
+		tiles.stopFades()
 	}
-}
 
-func (r *tileMapRenderer) processTileResults() {
-	processed := 0
-	for {
-		select {
-		case result := <-r.mapWidget.resultChan:
-			processed++
-			r.mapWidget.mu.Lock()
-			delete(r.mapWidget.tileFetching, result.Coord)
-			if result.Error == nil && result.Image != nil {
-				if result.Image.Bounds().Dx() > 0 && result.Image.Bounds().Dy() > 0 {
-					r.mapWidget.imageDataCache[result.Coord] = result.Image
-				} else {
-					log.Printf("Warning: Received invalid image dimensions for tile %v", result.Coord)
-				}
-			} else if result.Error != nil {
-
-				errorStr := result.Error.Error()
-				isKnownNotFound := errorStr == "tile not found (404)" || errorStr == "Mapbox API Error: 404 Not Found (Check User/Style/Coords)"
-				if !isKnownNotFound {
-					log.Printf("Error processing tile result %v: %v", result.Coord, result.Error)
-				}
-
-			} else {
-				log.Printf("Warning: Received nil image and nil error for tile %v", result.Coord)
-			}
-			r.mapWidget.mu.Unlock()
+	objects := make([]fyne.CanvasObject, 0, len(r.objects))
+	for _, layer := range layers {
+		objects = append(objects, layer.refresh(view)...)
+	}
+	r.objects = objects
+	viewChanged := view != r.lastView
+	r.lastView = view
 
-		default:
-			return
+	if viewChanged || styleChanged {
+		for _, watcher := range watchers {
+			watcher(view)
 		}
 	}
 }
@@ -504,132 +785,6 @@ func readTileFromCache(filePath string) (image.Image, error) {
 	return img, nil
 }
 
-func (r *tileMapRenderer) calculateRequiredTiles(zoom int, lat, lon float64, w, h float32) []TileCoord {
-	centerX, centerY := latLonToTileXY(lat, lon, zoom)
-	tilesX := int(math.Ceil(float64(w)/mapTileSize)) + 2
-	tilesY := int(math.Ceil(float64(h)/mapTileSize)) + 2
-	startX := int(math.Floor(centerX - float64(tilesX)/2.0))
-	startY := int(math.Floor(centerY - float64(tilesY)/2.0))
-	tiles := make([]TileCoord, 0, tilesX*tilesY)
-	maxTile := int(math.Pow(2, float64(zoom))) - 1
-	for x := startX; x < startX+tilesX; x++ {
-		for y := startY; y < startY+tilesY; y++ {
-			if y < 0 || y > maxTile {
-				continue
-			}
-			wrappedX := x
-			if maxTile >= 0 {
-				nWrap := maxTile + 1
-				wrappedX = (x%nWrap + nWrap) % nWrap
-			} else {
-				wrappedX = 0
-			}
-			tiles = append(tiles, TileCoord{Z: zoom, X: wrappedX, Y: y})
-		}
-	}
-	return tiles
-}
-
-func (r *tileMapRenderer) calculateTilePosition(coord TileCoord, zoom int, centerLat, centerLon float64, w, h float32) (float32, float32) {
-	n := math.Pow(2.0, float64(zoom))
-	centerPxX := ((centerLon + 180.0) / 360.0) * n * mapTileSize
-	centerPxY := (1.0 - math.Log(math.Tan(centerLat*math.Pi/180.0)+1.0/math.Cos(centerLat*math.Pi/180.0))/math.Pi) / 2.0 * n * mapTileSize
-	tilePxX := float64(coord.X) * mapTileSize
-	tilePxY := float64(coord.Y) * mapTileSize
-	offsetX := tilePxX - centerPxX
-	offsetY := tilePxY - centerPxY
-	screenX := (w / 2.0) + float32(offsetX)
-	screenY := (h / 2.0) + float32(offsetY)
-	return screenX, screenY
-}
-
-func (r *tileMapRenderer) fetchTileDataAsync(coord TileCoord) {
-	result := TileResult{Coord: coord}
-	fetchSuccessful := false
-	defer func() {
-		if !fetchSuccessful {
-			r.clearFetchingStatus(coord)
-		}
-		if rec := recover(); rec != nil {
-			log.Printf("Panic fetch %v: %v", coord, rec)
-			result.Error = fmt.Errorf("panic: %v", rec)
-			r.sendResultNonBlocking(result)
-		}
-	}()
-
-	ctx, cancel := context.WithTimeout(context.Background(), fetchTimeout)
-	defer cancel()
-
-	url := fmt.Sprintf("https://api.mapbox.com/styles/v1/%s/%s/tiles/%d/%d/%d/%d?access_token=%s", mapboxUsername, mapboxStyleID, mapTileSize, coord.Z, coord.X, coord.Y, mapboxAccessToken)
-	fmt.Println("Fetching tile:", url) // Keep commented unless debugging
-
-	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
-	if err != nil {
-		result.Error = fmt.Errorf("req fail: %w", err)
-		r.sendResult(result)
-		return
-	}
-	req.Header.Set("User-Agent", yourUserAgent)
-	resp, err := httpClient.Do(req)
-	if resp != nil {
-		defer resp.Body.Close()
-	}
-	if err != nil {
-		if ctx.Err() == context.DeadlineExceeded {
-			result.Error = fmt.Errorf("timeout")
-		} else if ctx.Err() == context.Canceled {
-			result.Error = fmt.Errorf("cancelled")
-		} else {
-			result.Error = fmt.Errorf("http fail: %w", err)
-		}
-		r.sendResult(result)
-		return
-	}
-	if resp.StatusCode == http.StatusUnauthorized {
-		result.Error = fmt.Errorf("Mapbox API Error: %s (Check Token)", resp.Status)
-		r.sendResult(result)
-		return
-	}
-	if resp.StatusCode == http.StatusNotFound {
-		result.Error = fmt.Errorf("Mapbox API Error: %s (Check User/Style/Coords)", resp.Status)
-		r.sendResult(result)
-		return
-	} // Treat 404 as an error to not cache it
-	if resp.StatusCode != http.StatusOK {
-		result.Error = fmt.Errorf("http status %s", resp.Status)
-		r.sendResult(result)
-		return
-	}
-
-	imgData, err := png.Decode(resp.Body)
-	if err != nil {
-		result.Error = fmt.Errorf("png decode fail: %w", err)
-		r.sendResult(result)
-		return
-	}
-	if imgData == nil || imgData.Bounds().Dx() <= 0 || imgData.Bounds().Dy() <= 0 {
-		result.Error = fmt.Errorf("bad img")
-		r.sendResult(result)
-		return
-	}
-
-	// --- START Cache Write ---
-	if tileCachePath != "" { // Only write if cache path is set
-		tileFilePath := getTileFilePath(coord)
-		err := writeTileToCache(tileFilePath, imgData)
-		if err != nil {
-			log.Printf("Warning: Failed to write tile %v to cache '%s': %v", coord, tileFilePath, err)
-		} else {
-			// log.Printf("Cache WRITE: %v", coord) // For debugging
-		}
-	}
-	// --- END Cache Write ---
-
-	result.Image = imgData
-	fetchSuccessful = true
-	r.sendResult(result)
-}
-
 func writeTileToCache(filePath string, img image.Image) error {
 	cacheWriteMutex.Lock()
 	defer cacheWriteMutex.Unlock()
@@ -668,42 +823,13 @@ func writeTileToCache(filePath string, img image.Image) error {
 	return nil
 }
 
-func (r *tileMapRenderer) sendResult(result TileResult) {
-	select {
-	case r.mapWidget.resultChan <- result:
-	case <-r.mapWidget.stopChan:
-		log.Printf("Sending cancelled for tile %v result (widget stopped)", result.Coord)
-		r.clearFetchingStatus(result.Coord)
-	case <-time.After(sendTimeout):
-		log.Printf("Timeout sending result for tile %v. Discarding.", result.Coord)
-		r.clearFetchingStatus(result.Coord)
-	}
-}
-
-func (r *tileMapRenderer) sendResultNonBlocking(result TileResult) {
-	select {
-	case r.mapWidget.resultChan <- result:
-	case <-r.mapWidget.stopChan:
-	default:
-		log.Printf("Failed non-blocking send for tile %v", result.Coord)
-	}
-}
-
-func (r *tileMapRenderer) clearFetchingStatus(coord TileCoord) {
-	r.mapWidget.mu.Lock()
-	delete(r.mapWidget.tileFetching, coord)
-	r.mapWidget.mu.Unlock()
-}
-
 func (r *tileMapRenderer) Objects() []fyne.CanvasObject {
-	r.mapWidget.mu.RLock()
-	objs := make([]fyne.CanvasObject, len(r.objects))
-	copy(objs, r.objects)
-	r.mapWidget.mu.RUnlock()
-	return objs
+	return r.objects
 }
 
-func (r *tileMapRenderer) Destroy() {}
+func (r *tileMapRenderer) Destroy() {
+	r.mapWidget.Destroy()
+}
 
 // func latLonToTileXY(lat, lon float64, zoom int) (float64, float64) {
 // 	latRad := lat * math.Pi / 180.0
@@ -716,29 +842,230 @@ func (r *tileMapRenderer) Destroy() {}
 // =====================================================
 // Chart Widget Code
 // =====================================================
+const (
+	chartSampleCapacity = 60
+	chartSampleInterval = time.Second
+	// chartHeadroom keeps the highest sample below the top of the chart.
+	chartHeadroom = 1.15
+	// chartMinMaxY is the smallest top of the Y axis, in bytes per second.
+	chartMinMaxY = 1024
+	// chartOverlayAlpha is the opacity of overlaid series.
+	chartOverlayAlpha = 0.7
+)
+
+var (
+	bytesInColor  = color.NRGBA{R: 0xff, G: 0xd7, B: 0x00, A: 0xff}
+	bytesOutColor = color.NRGBA{R: 0x4f, G: 0xc3, B: 0xf7, A: 0xff}
+)
+
+// chartSeries is one named line of samples in a connectionStatsChart.
+type chartSeries struct {
+	name    string
+	color   color.Color
+	samples *sampleRing
+}
+
 type connectionStatsChart struct {
 	widget.BaseWidget
-	title     string
-	data      []float64
+	title string
+	// OnAppend is called on the UI thread with the samples of each Append,
+	// one per series.
+	OnAppend func(samples []float64)
+
+	mu        sync.Mutex
+	capacity  int
+	series    []*chartSeries
+	stacked   bool
 	maxY      float64
 	yLabelMax string
 	yLabelMin string
 }
 
-func newConnectionStatsChart(title string, data []float64, maxY float64, yLabelMax, yLabelMin string) *connectionStatsChart {
-	c := &connectionStatsChart{
-		title:     title,
-		data:      data,
-		maxY:      maxY,
-		yLabelMax: yLabelMax,
-		yLabelMin: yLabelMin,
-	}
+// chartSnapshot is a copy of the chart's state for the renderer.
+type chartSnapshot struct {
+	series    []chartSeriesSnapshot
+	capacity  int
+	stacked   bool
+	maxY      float64
+	yLabelMax string
+	yLabelMin string
+}
+
+type chartSeriesSnapshot struct {
+	name  string
+	color color.Color
+	data  []float64
+}
+
+// newConnectionStatsChart creates an empty throughput chart that keeps the
+// last capacity samples of each series, in bytes per second. Its Y axis
+// grows and shrinks with the samples.
+func newConnectionStatsChart(title string, capacity int) *connectionStatsChart {
+	c := &connectionStatsChart{title: title, capacity: capacity}
+	c.rescaleLocked()
 	c.ExtendBaseWidget(c)
 	return c
 }
 
+// AddSeries adds a series drawn in col and named in the legend. Append
+// takes the samples of the series in the order they were added.
+func (c *connectionStatsChart) AddSeries(name string, col color.Color) {
+	c.mu.Lock()
+	c.series = append(c.series, &chartSeries{name: name, color: col, samples: newSampleRing(c.capacity)})
+	c.mu.Unlock()
+	c.Refresh()
+}
+
+// SetStacked draws each series on top of the previous ones, showing their
+// total, instead of overlaying them.
+func (c *connectionStatsChart) SetStacked(stacked bool) {
+	c.mu.Lock()
+	c.stacked = stacked
+	c.rescaleLocked()
+	c.mu.Unlock()
+	c.Refresh()
+}
+
+// Append adds one sample to each series, dropping the oldest once the chart
+// is full. Missing samples count as zero. It may be called from any goroutine.
+func (c *connectionStatsChart) Append(samples ...float64) {
+	c.mu.Lock()
+	appended := make([]float64, len(c.series))
+	copy(appended, samples)
+	for i, series := range c.series {
+		series.samples.push(appended[i])
+	}
+	c.rescaleLocked()
+	c.mu.Unlock()
+	fyne.Do(func() {
+		c.Refresh()
+		if c.OnAppend != nil && len(appended) > 0 {
+			c.OnAppend(appended)
+		}
+	})
+}
+
+// rescaleLocked fits the Y axis to the highest bar with some headroom,
+// rounded up to a readable rate.
+func (c *connectionStatsChart) rescaleLocked() {
+	peak := 0.0
+	if c.stacked {
+		totals := make([]float64, c.capacity)
+		for _, series := range c.series {
+			data := series.samples.values()
+			for i, v := range data {
+				totals[c.capacity-len(data)+i] += math.Max(v, 0)
+			}
+		}
+		for _, total := range totals {
+			peak = math.Max(peak, total)
+		}
+	} else {
+		for _, series := range c.series {
+			peak = math.Max(peak, series.samples.max())
+		}
+	}
+	c.maxY = niceByteRate(math.Max(peak*chartHeadroom, chartMinMaxY))
+	c.yLabelMax = formatByteRate(c.maxY)
+	c.yLabelMin = formatByteRate(0)
+}
+
+func (c *connectionStatsChart) snapshot() chartSnapshot {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	snap := chartSnapshot{capacity: c.capacity, stacked: c.stacked, maxY: c.maxY, yLabelMax: c.yLabelMax, yLabelMin: c.yLabelMin}
+	for _, series := range c.series {
+		snap.series = append(snap.series, chartSeriesSnapshot{name: series.name, color: series.color, data: series.samples.values()})
+	}
+	return snap
+}
+
+// niceCeil rounds v up to 1, 2, 2.5 or 5 times a power of ten.
+func niceCeil(v float64) float64 {
+	if v <= 0 {
+		return 1
+	}
+	exp := math.Pow(10, math.Floor(math.Log10(v)))
+	for _, m := range []float64{1, 2, 2.5, 5} {
+		if m*exp >= v {
+			return m * exp
+		}
+	}
+	return 10 * exp
+}
+
+// niceByteRate rounds a rate up to a readable value in the unit formatByteRate
+// shows it in, like 20KB/s rather than 19.5KB/s.
+func niceByteRate(bytesPerSecond float64) float64 {
+	scale := 1.0
+	for bytesPerSecond/scale >= 1024 {
+		scale *= 1024
+	}
+	if nice := niceCeil(bytesPerSecond/scale) * scale; nice < 1024*scale {
+		return nice
+	}
+	return 1024 * scale
+}
+
+// formatByteRate formats a rate in bytes per second the way the chart labels it, like 11.2KB/s.
+func formatByteRate(bytesPerSecond float64) string {
+	units := []string{"B/s", "KB/s", "MB/s", "GB/s"}
+	v, i := bytesPerSecond, 0
+	for v >= 1024 && i < len(units)-1 {
+		v /= 1024
+		i++
+	}
+	if i == 0 || v >= 100 || v == math.Trunc(v) {
+		return fmt.Sprintf("%.0f%s", v, units[i])
+	}
+	return fmt.Sprintf("%.1f%s", v, units[i])
+}
+
+// sampleRing keeps the latest samples of a series in a fixed-size buffer.
+type sampleRing struct {
+	buf   []float64
+	start int
+	count int
+}
+
+func newSampleRing(capacity int) *sampleRing {
+	return &sampleRing{buf: make([]float64, capacity)}
+}
+
+func (r *sampleRing) capacity() int { return len(r.buf) }
+
+// push adds v, overwriting the oldest sample when the ring is full.
+func (r *sampleRing) push(v float64) {
+	if len(r.buf) == 0 {
+		return
+	}
+	if r.count < len(r.buf) {
+		r.buf[(r.start+r.count)%len(r.buf)] = v
+		r.count++
+		return
+	}
+	r.buf[r.start] = v
+	r.start = (r.start + 1) % len(r.buf)
+}
+
+// values returns a copy of the samples, oldest first.
+func (r *sampleRing) values() []float64 {
+	out := make([]float64, r.count)
+	for i := range out {
+		out[i] = r.buf[(r.start+i)%len(r.buf)]
+	}
+	return out
+}
+
+func (r *sampleRing) max() float64 {
+	peak := 0.0
+	for i := 0; i < r.count; i++ {
+		peak = math.Max(peak, r.buf[(r.start+i)%len(r.buf)])
+	}
+	return peak
+}
+
 func (c *connectionStatsChart) CreateRenderer() fyne.WidgetRenderer {
-	dataColor := color.NRGBA{R: 0xff, G: 0xd7, B: 0x00, A: 0xff}
 	titleText := canvas.NewText(c.title, theme.ForegroundColor())
 	titleText.TextStyle = fyne.TextStyle{Bold: true}
 	titleText.TextSize = theme.TextSize() * 1.4
@@ -747,17 +1074,14 @@ func (c *connectionStatsChart) CreateRenderer() fyne.WidgetRenderer {
 	r := &connectionStatsChartRenderer{
 		chart:     c,
 		titleText: titleText,
-		yLabelMax: canvas.NewText(c.yLabelMax, labelColor),
-		yLabelMin: canvas.NewText(c.yLabelMin, labelColor),
+		yLabelMax: canvas.NewText("", labelColor),
+		yLabelMin: canvas.NewText("", labelColor),
 		topLine:   canvas.NewLine(lineColor),
 		midLine:   canvas.NewLine(lineColor),
 		botLine:   canvas.NewLine(lineColor),
-		dataLines: []fyne.CanvasObject{},
-		dataColor: dataColor,
 	}
 	r.yLabelMax.TextSize = theme.TextSize() * 1.1
 	r.yLabelMin.TextSize = theme.TextSize() * 1.1
-	r.updateDataLines()
 	r.Refresh()
 	return r
 }
@@ -770,16 +1094,33 @@ type connectionStatsChartRenderer struct {
 	topLine   *canvas.Line
 	midLine   *canvas.Line
 	botLine   *canvas.Line
-	dataLines []fyne.CanvasObject
-	dataColor color.Color
+	// bars holds one bar per sample for each series.
+	bars     [][]*canvas.Line
+	swatches []*canvas.Rectangle
+	names    []*canvas.Text
+
+	// The chart's state as of the last Refresh.
+	state chartSnapshot
 }
 
-func (r *connectionStatsChartRenderer) Layout(size fyne.Size) {
-	padding := theme.Padding()
-	titleHeight := float32(0)
+// headerHeight is the height of the title and legend row.
+func (r *connectionStatsChartRenderer) headerHeight() float32 {
+	height := float32(0)
 	if r.chart.title != "" {
-		titleHeight = r.titleText.MinSize().Height + padding
+		height = r.titleText.MinSize().Height
+	}
+	for _, name := range r.names {
+		height = float32(math.Max(float64(height), float64(name.MinSize().Height)))
+	}
+	if height > 0 {
+		height += theme.Padding()
 	}
+	return height
+}
+
+func (r *connectionStatsChartRenderer) Layout(size fyne.Size) {
+	padding := theme.Padding()
+	titleHeight := r.headerHeight()
 	labelWidth := float32(math.Max(float64(r.yLabelMax.MinSize().Width), float64(r.yLabelMin.MinSize().Width))) + padding*1.5
 	if r.chart.title != "" {
 		r.titleText.Move(fyne.NewPos(padding, padding/2))
@@ -787,6 +1128,7 @@ func (r *connectionStatsChartRenderer) Layout(size fyne.Size) {
 	} else {
 		r.titleText.Hide()
 	}
+	r.layoutLegend(size, titleHeight)
 	chartAreaX := labelWidth
 	chartAreaWidth := size.Width - labelWidth - padding
 	chartAreaY := titleHeight + r.yLabelMax.MinSize().Height/2
@@ -809,56 +1151,74 @@ func (r *connectionStatsChartRenderer) Layout(size fyne.Size) {
 	r.midLine.Position2 = fyne.NewPos(lineEndX, midY)
 	r.botLine.Position1 = fyne.NewPos(chartAreaX, botY)
 	r.botLine.Position2 = fyne.NewPos(lineEndX, botY)
-	if len(r.chart.data) == 0 || chartAreaWidth <= 0 || chartAreaHeight <= 0 || r.chart.maxY <= 0 {
-		for _, line := range r.dataLines {
-			line.Hide()
+	visible := r.state.capacity > 0 && chartAreaWidth > 0 && chartAreaHeight > 0 && r.state.maxY > 0
+	for _, bars := range r.bars {
+		for _, bar := range bars {
+			bar.Hidden = !visible
 		}
+	}
+	if !visible {
 		return
-	} else {
-		for _, line := range r.dataLines {
-			line.Show()
-		}
 	}
-	stepX := chartAreaWidth / float32(len(r.chart.data))
-	scaleY := chartAreaHeight / float32(r.chart.maxY)
-	for i, lineObj := range r.dataLines {
-		if castLine, ok := lineObj.(*canvas.Line); ok {
-			dataVal := r.chart.data[i]
-			if dataVal < 0 {
-				dataVal = 0
-			}
-			xPos := chartAreaX + float32(i)*stepX
-			dataHeight := float32(dataVal) * scaleY
-			if dataHeight > chartAreaHeight {
-				dataHeight = chartAreaHeight
-			}
-			if dataHeight < 0 {
-				dataHeight = 0
+
+	// Samples fill the chart from the right, one slot per sample of capacity.
+	// Stacked bars start where the previous series' bar in the slot ended.
+	stepX := chartAreaWidth / float32(r.state.capacity)
+	scaleY := chartAreaHeight / float32(r.state.maxY)
+	stackBase := make([]float32, r.state.capacity)
+	for s, series := range r.state.series {
+		firstSlot := r.state.capacity - len(series.data)
+		for i, bar := range r.bars[s] {
+			slot := firstSlot + i
+			dataHeight := float32(math.Max(series.data[i], 0)) * scaleY
+			base := float32(0)
+			if r.state.stacked {
+				base = stackBase[slot]
+				stackBase[slot] += dataHeight
 			}
-			yPosData := botY - dataHeight
-			castLine.Position1 = fyne.NewPos(xPos, botY)
-			castLine.Position2 = fyne.NewPos(xPos, yPosData)
-			castLine.StrokeWidth = float32(math.Max(1.0, float64(stepX*0.95)))
+			xPos := chartAreaX + float32(slot)*stepX
+			bar.Position1 = fyne.NewPos(xPos, botY-float32(math.Min(float64(base), float64(chartAreaHeight))))
+			bar.Position2 = fyne.NewPos(xPos, botY-float32(math.Min(float64(base+dataHeight), float64(chartAreaHeight))))
+			bar.StrokeWidth = float32(math.Max(1.0, float64(stepX*0.95)))
 		}
 	}
 }
 
+// layoutLegend right-aligns the series names on the title row.
+func (r *connectionStatsChartRenderer) layoutLegend(size fyne.Size, headerHeight float32) {
+	padding := theme.Padding()
+	x := size.Width - padding
+	for i := len(r.names) - 1; i >= 0; i-- {
+		name, swatch := r.names[i], r.swatches[i]
+		nameSize := name.MinSize()
+		swatchSize := nameSize.Height * 0.6
+		x -= nameSize.Width
+		name.Move(fyne.NewPos(x, (headerHeight-nameSize.Height)/2))
+		x -= swatchSize + padding
+		swatch.Resize(fyne.NewSquareSize(swatchSize))
+		swatch.Move(fyne.NewPos(x, (headerHeight-swatchSize)/2))
+		x -= padding * 3
+	}
+}
+
 func (r *connectionStatsChartRenderer) MinSize() fyne.Size {
-	titleMinHeight, titleMinWidth := float32(0), float32(0)
+	titleMinWidth := float32(0)
 	if r.chart.title != "" {
-		titleMin := r.titleText.MinSize()
-		titleMinHeight = titleMin.Height
-		titleMinWidth = titleMin.Width
+		titleMinWidth = r.titleText.MinSize().Width
+	}
+	for _, name := range r.names {
+		titleMinWidth += name.MinSize().Height*0.6 + name.MinSize().Width + theme.Padding()*4
 	}
 	labelWidth := float32(math.Max(float64(r.yLabelMax.MinSize().Width), float64(r.yLabelMin.MinSize().Width)))
 	minChartAreaHeight := theme.TextSize() * 15
 	minChartAreaWidth := float32(50)
-	minHeight := titleMinHeight + r.yLabelMax.MinSize().Height + r.yLabelMin.MinSize().Height + minChartAreaHeight + theme.Padding()*4
+	minHeight := r.headerHeight() + r.yLabelMax.MinSize().Height + r.yLabelMin.MinSize().Height + minChartAreaHeight + theme.Padding()*3
 	minWidth := float32(math.Max(float64(titleMinWidth), float64(labelWidth+minChartAreaWidth))) + theme.Padding()*3
 	return fyne.NewSize(minWidth, minHeight)
 }
 
 func (r *connectionStatsChartRenderer) Refresh() {
+	r.state = r.chart.snapshot()
 	r.titleText.Color = theme.ForegroundColor()
 	r.yLabelMax.Color = theme.ForegroundColor()
 	r.yLabelMin.Color = theme.ForegroundColor()
@@ -866,46 +1226,69 @@ func (r *connectionStatsChartRenderer) Refresh() {
 	r.midLine.StrokeColor = theme.DisabledColor()
 	r.botLine.StrokeColor = theme.DisabledColor()
 	r.titleText.Text = r.chart.title
-	r.yLabelMax.Text = r.chart.yLabelMax
-	r.yLabelMin.Text = r.chart.yLabelMin
+	r.yLabelMax.Text, r.yLabelMin.Text = r.state.yLabelMax, r.state.yLabelMin
 	r.titleText.Refresh()
 	r.yLabelMax.Refresh()
 	r.yLabelMin.Refresh()
-	r.updateDataLines()
+	r.updateSeries()
 	r.topLine.Refresh()
 	r.midLine.Refresh()
 	r.botLine.Refresh()
-	for _, line := range r.dataLines {
-		line.Refresh()
-	}
 	r.Layout(r.chart.Size())
+	for _, bars := range r.bars {
+		for _, bar := range bars {
+			bar.Refresh()
+		}
+	}
 }
 
-func (r *connectionStatsChartRenderer) updateDataLines() {
-	currentLen := len(r.dataLines)
-	targetLen := len(r.chart.data)
-	if currentLen < targetLen {
-		for i := currentLen; i < targetLen; i++ {
-			line := canvas.NewLine(r.dataColor)
-			line.StrokeWidth = 1
-			r.dataLines = append(r.dataLines, line)
+// updateSeries matches the bars and legend entries to the series.
+func (r *connectionStatsChartRenderer) updateSeries() {
+	for len(r.bars) < len(r.state.series) {
+		swatch := canvas.NewRectangle(color.Transparent)
+		name := canvas.NewText("", theme.ForegroundColor())
+		name.TextSize = theme.TextSize() * 1.1
+		r.bars = append(r.bars, nil)
+		r.swatches = append(r.swatches, swatch)
+		r.names = append(r.names, name)
+	}
+	for s, series := range r.state.series {
+		barColor := series.color
+		if !r.state.stacked {
+			// Overlaid bars let the series behind them show through.
+			c := color.NRGBAModel.Convert(series.color).(color.NRGBA)
+			c.A = uint8(float64(c.A) * chartOverlayAlpha)
+			barColor = c
 		}
-	} else if currentLen > targetLen {
-		r.dataLines = r.dataLines[:targetLen]
-	}
-	for _, obj := range r.dataLines {
-		if line, ok := obj.(*canvas.Line); ok {
-			line.StrokeColor = r.dataColor
+		for len(r.bars[s]) < len(series.data) {
+			r.bars[s] = append(r.bars[s], canvas.NewLine(barColor))
 		}
+		r.bars[s] = r.bars[s][:len(series.data)]
+		for _, bar := range r.bars[s] {
+			bar.StrokeColor = barColor
+		}
+		r.swatches[s].FillColor = series.color
+		r.swatches[s].Refresh()
+		r.names[s].Text = series.name
+		r.names[s].Color = theme.ForegroundColor()
+		r.names[s].Refresh()
 	}
 }
 
 func (r *connectionStatsChartRenderer) Objects() []fyne.CanvasObject {
-	objects := r.dataLines
+	objects := make([]fyne.CanvasObject, 0, len(r.bars)*r.state.capacity+6+len(r.names)*2)
+	for _, bars := range r.bars {
+		for _, bar := range bars {
+			objects = append(objects, bar)
+		}
+	}
 	objects = append(objects, r.topLine, r.midLine, r.botLine)
 	if r.chart.title != "" {
 		objects = append(objects, r.titleText)
 	}
+	for i := range r.names {
+		objects = append(objects, r.swatches[i], r.names[i])
+	}
 	objects = append(objects, r.yLabelMax, r.yLabelMin)
 	return objects
 }
@@ -937,72 +1320,174 @@ func createLoggedInScreen() fyne.CanvasObject {
 	userInfo := container.NewVBox(userName, userEmail)
 	topBar := container.NewHBox(layout.NewSpacer(), userInfo)
 
-	quickConnectButton := widget.NewButton("[Quick Connect]", func() {
-		fmt.Println("Quick Connect clicked")
-		connectedContent := createConnectedScreen("Quick Connect Gateway", "auto-region", "your-device (IP unknown)")
-		mainWindow.SetContent(connectedContent)
-	})
-
 	gatewaysLabel := widget.NewLabelWithStyle("Gateways", fyne.TextAlignLeading, fyne.TextStyle{Bold: true})
 	recentConnectionsLabel := widget.NewLabel("Recent connections")
 	tabsHeader := container.NewHBox(gatewaysLabel, layout.NewSpacer(), recentConnectionsLabel)
 
-	gatewayList := container.NewVBox()
-
+	// The gateways are not pinged yet: latency is a fixed placeholder per
+	// gateway, used by the tooltips and Quick Connect until it is measured.
 	gateways := []struct {
-		name   string
-		region string
-		lat    float64
-		lon    float64
+		name    string
+		region  string
+		lat     float64
+		lon     float64
+		latency time.Duration
 	}{
-		{"Ho Chi Minh Office", "ap-southeast-3", 10.7769, 106.7009},
-		{"Thailand Office", "ap-southeast-2", 13.7563, 100.5018},
-		{"Germany Office", "europe-3", 50.1109, 8.6821},
+		{"Ho Chi Minh Office", "ap-southeast-3", 10.7769, 106.7009, 18 * time.Millisecond},
+		{"Thailand Office", "ap-southeast-2", 13.7563, 100.5018, 42 * time.Millisecond},
+		{"Germany Office", "europe-3", 50.1109, 8.6821, 187 * time.Millisecond},
 	}
 
 	gatewayMarkers := make([]*MapMarker, 0, len(gateways))
 	for _, gw := range gateways {
-		gwName := gw.name
-		gwRegion := gw.region
-
-		nameLabel := widget.NewLabel(gwName)
-		regionLabel := widget.NewLabel(gwRegion)
-		infoVBox := container.NewVBox(nameLabel, regionLabel)
-		connectButton := widget.NewButton("[Connect]", func() {
-			fmt.Printf("Connect clicked for: %s\n", gwName)
-			deviceName := "john-laptop (100.100.24.3)"
-			connectedContent := createConnectedScreen(gwName, gwRegion, deviceName)
-			mainWindow.SetContent(connectedContent)
-		})
-		row := container.NewHBox(infoVBox, layout.NewSpacer(), connectButton)
-		gatewayList.Add(row)
-		gatewayList.Add(widget.NewSeparator())
-
 		gatewayMarkers = append(gatewayMarkers, &MapMarker{
-			ID:   gw.region,
-			Lat:  gw.lat,
-			Lon:  gw.lon,
-			Name: gwName,
+			ID:                 gw.region,
+			Lat:                gw.lat,
+			Lon:                gw.lon,
+			Name:               gw.name,
+			Region:             gw.region,
+			Latency:            gw.latency,
+			LatencyPlaceholder: true,
 		})
 	}
 
-	leftSideContent := container.NewVBox(
-		quickConnectButton,
-		widget.NewSeparator(),
-		tabsHeader,
-		widget.NewSeparator(),
-		gatewayList,
-		layout.NewSpacer(),
-	)
-
 	// mapStartZoom := 12
 	mapStartLat := gateways[0].lat
 	mapStartLon := gateways[0].lon
 
-	mapWidget := NewTileMapWidget(minZoom, mapStartLat, mapStartLon, mainWindow)
+	mapWidget := NewTileMapWidget(minZoom, mapStartLat, mapStartLon)
 	mapWidget.AddMarkers(gatewayMarkers...)
+	if err := mapWidget.AddGeoJSON("regions", regionsGeoJSON, GeoJSONStyle{}); err != nil {
+		log.Printf("Warning: Failed to load region overlay: %v", err)
+	}
+	heatmap := NewHeatmapLayer("latency", 0.55)
+	heatmap.SetSamples(latencySamples...)
+	mapWidget.AddLayer(heatmap)
+	mapWidget.SetLayerVisible(heatmap.LayerID(), false)
+	if deviceLocation != nil {
+		mapWidget.SetUserLocation(deviceLocation.LatLng, deviceLocation.AccuracyKm*1000)
+	}
+	mapWidget.FitMarkers()
+	mapWidget.SetControlsVisible(true)
+
+	// Ctrl+Shift+D (Cmd+Shift+D on macOS) toggles the tile debug overlay.
+	toggleDebug := &desktop.CustomShortcut{KeyName: fyne.KeyD, Modifier: fyne.KeyModifierShortcutDefault | fyne.KeyModifierShift}
+	mainWindow.Canvas().AddShortcut(toggleDebug, func(fyne.Shortcut) {
+		mapWidget.SetDebugOverlay(!mapWidget.DebugOverlay())
+	})
+
+	heatmapCheck := widget.NewCheck("Latency heatmap", func(show bool) {
+		mapWidget.SetLayerVisible(heatmap.LayerID(), show)
+	})
+	stylePicker := newMapStylePicker(mapWidget, mapStyleChoices)
+	exportButton := widget.NewButtonWithIcon("Export map", theme.DocumentSaveIcon(), func() {
+		showExportMapDialog(mapWidget, mainWindow)
+	})
+
+	gatewayIndex := func(id string) int {
+		for i, gw := range gateways {
+			if gw.region == id {
+				return i
+			}
+		}
+		return -1
+	}
+
+	connectTo := func(i int) {
+		gwName, gwRegion := gateways[i].name, gateways[i].region
+		gwCenter := LatLng{Lat: gateways[i].lat, Lon: gateways[i].lon}
+		route := []LatLng{gwCenter}
+		if deviceLocation != nil {
+			route = []LatLng{deviceLocation.LatLng, gwCenter}
+		}
+		_, zoom := mapWidget.View()
+		mapWidget.FlyTo(gwCenter, zoom, func() {
+			deviceName := "john-laptop (100.100.24.3)"
+			connectedContent := createConnectedScreen(gwName, gwRegion, deviceName, route)
+			mainWindow.SetContent(connectedContent)
+		})
+	}
 
-	rightSideContent := container.NewMax(mapWidget)
+	quickConnectButton := widget.NewButton("[Quick Connect]", func() {
+		fmt.Println("Quick Connect clicked")
+		// Prefer the gateway nearest the device, else the one with the lowest
+		// (placeholder) latency.
+		best := 0
+		if deviceLocation != nil {
+			if nearest := nearestMarker(deviceLocation.LatLng, gatewayMarkers); nearest != nil {
+				best = gatewayIndex(nearest.ID)
+			}
+		} else {
+			for i, gw := range gateways {
+				if gw.latency < gateways[best].latency {
+					best = i
+				}
+			}
+		}
+		connectTo(best)
+	})
+
+	gatewayList := widget.NewList(
+		func() int { return len(gateways) },
+		func() fyne.CanvasObject {
+			infoVBox := container.NewVBox(widget.NewLabel(""), widget.NewLabel(""))
+			return container.NewHBox(infoVBox, layout.NewSpacer(), widget.NewButton("[Connect]", nil))
+		},
+		func(id widget.ListItemID, obj fyne.CanvasObject) {
+			gwName := gateways[id].name
+			row := obj.(*fyne.Container)
+			infoVBox := row.Objects[0].(*fyne.Container)
+			infoVBox.Objects[0].(*widget.Label).SetText(gwName)
+			infoVBox.Objects[1].(*widget.Label).SetText(gateways[id].region)
+			row.Objects[2].(*widget.Button).OnTapped = func() {
+				fmt.Printf("Connect clicked for: %s\n", gwName)
+				connectTo(id)
+			}
+		},
+	)
+
+	leftSideContent := container.NewBorder(
+		container.NewVBox(
+			quickConnectButton,
+			widget.NewSeparator(),
+			tabsHeader,
+			widget.NewSeparator(),
+		),
+		container.NewHBox(heatmapCheck, layout.NewSpacer(), stylePicker, exportButton),
+		nil, nil,
+		gatewayList,
+	)
+
+	gatewayList.OnSelected = func(id widget.ListItemID) {
+		mapWidget.SelectMarker(gateways[id].region)
+	}
+	gatewayList.OnUnselected = func(id widget.ListItemID) {
+		if mapWidget.SelectedMarkerID() == gateways[id].region {
+			mapWidget.SelectMarker("")
+		}
+	}
+	mapWidget.OnMarkerTapped = func(marker *MapMarker) {
+		if i := gatewayIndex(marker.ID); i >= 0 {
+			gatewayList.Select(i)
+		}
+	}
+	mapWidget.OnMarkerHovered = func(marker *MapMarker) {
+		if marker == nil {
+			return
+		}
+		if i := gatewayIndex(marker.ID); i >= 0 {
+			gatewayList.ScrollTo(i)
+		}
+	}
+	mapWidget.OnMapTapped = func(pos LatLng) {
+		gatewayList.UnselectAll()
+	}
+
+	minimap := NewMiniMapWidget(mapWidget, LatLng{Lat: 30, Lon: 60}, 0)
+	rightSideContent := container.NewStack(
+		mapWidget,
+		container.NewBorder(container.NewHBox(container.NewPadded(minimap)), nil, nil, nil),
+	)
 
 	centerSplit := container.NewHSplit(
 		container.NewPadded(leftSideContent),
@@ -1019,13 +1504,74 @@ func createLoggedInScreen() fyne.CanvasObject {
 	return loggedInLayout
 }
 
-func createConnectedScreen(gatewayName, gatewayRegion, deviceName string) fyne.CanvasObject {
+// showExportMapDialog asks for an image size and a file, then saves the map's
+// current view there as a PNG. Larger sizes show the same area at a deeper
+// zoom, once they are large enough for the next zoom level.
+func showExportMapDialog(mapWidget *TileMapWidget, win fyne.Window) {
+	view := mapWidget.currentView()
+	sizes := map[string][2]int{
+		"Current view": {int(view.width), int(view.height)},
+		"1280 x 720":   {1280, 720},
+		"1920 x 1080":  {1920, 1080},
+		"3840 x 2160":  {3840, 2160},
+	}
+	sizeSelect := widget.NewSelect([]string{"Current view", "1280 x 720", "1920 x 1080", "3840 x 2160"}, nil)
+	sizeSelect.SetSelected("Current view")
+
+	dialog.ShowForm("Export map", "Export", "Cancel", []*widget.FormItem{widget.NewFormItem("Size", sizeSelect)}, func(ok bool) {
+		if !ok {
+			return
+		}
+		size := sizes[sizeSelect.Selected]
+		save := dialog.NewFileSave(func(writer fyne.URIWriteCloser, err error) {
+			if err != nil {
+				dialog.ShowError(err, win)
+				return
+			}
+			if writer == nil {
+				return
+			}
+			go func() {
+				img, err := mapWidget.RenderVisibleImage(size[0], size[1])
+				if err == nil {
+					err = png.Encode(writer, img)
+				}
+				if closeErr := writer.Close(); err == nil {
+					err = closeErr
+				}
+				fyne.Do(func() {
+					if err != nil {
+						dialog.ShowError(fmt.Errorf("failed to export map: %w", err), win)
+						return
+					}
+					dialog.ShowInformation("Export map", "Saved "+writer.URI().Name(), win)
+				})
+			}()
+		}, win)
+		save.SetFileName("gateway-map.png")
+		save.SetFilter(storage.NewExtensionFileFilter([]string{".png"}))
+		save.Show()
+	}, win)
+}
+
+// createConnectedScreen shows the active connection. route lists the user's
+// location, any intermediate hops and the gateway; it is just the gateway
+// when the user's location is unknown, and empty when the gateway location
+// is unknown too.
+func createConnectedScreen(gatewayName, gatewayRegion, deviceName string, route []LatLng) fyne.CanvasObject {
+
+	// stopFeed ends the chart feed when the screen is left.
+	stopFeed := make(chan struct{})
+	var leaveOnce sync.Once
+	leave := func(next func() fyne.CanvasObject) {
+		leaveOnce.Do(func() { close(stopFeed) })
+		mainWindow.SetContent(next())
+	}
 
 	userNameLabel := widget.NewLabel("Vinh Nguyen")
 	logoutButton := widget.NewButton("[Logout]", func() {
 		fmt.Println("Logout clicked")
-		loginContent := createLoginScreen()
-		mainWindow.SetContent(loginContent)
+		leave(createLoginScreen)
 	})
 	topBar := container.NewHBox(layout.NewSpacer(), userNameLabel, logoutButton)
 
@@ -1037,23 +1583,28 @@ func createConnectedScreen(gatewayName, gatewayRegion, deviceName string) fyne.C
 	connectionDetailsHBox := container.NewHBox(gatewayDetailsVBox, layout.NewSpacer(), deviceLabel)
 	disconnectButton := widget.NewButton("[Disconnect]", func() {
 		fmt.Println("Disconnect clicked")
-		loggedInContent := createLoggedInScreen()
-		mainWindow.SetContent(loggedInContent)
+		leave(createLoggedInScreen)
 	})
 	connectionInfoSection := container.NewVBox(statusLabel, connectionDetailsHBox, disconnectButton)
 
-	chartData := []float64{0.1, 0.1, 0.2, 0.1, 0.2, 0.3, 0.2, 0.1, 0.1, 0.1, 0.1, 0.1, 0.1, 0.1, 0.1, 0.1, 0.1, 0.2, 0.3, 0.5, 0.8, 1.2, 1.8, 2.5, 3.0, 2.2, 1.5, 0.8, 0.5, 0.3, 0.2, 0.2, 0.2, 0.3, 0.4, 0.8, 1.5, 2.5, 4.0, 6.0, 8.5, 10.0, 11.0, 10.8, 9.5, 8.0, 6.5, 5.5, 4.8, 4.0, 3.5, 3.0, 2.6, 2.2, 1.8, 1.5, 1.2, 1.0, 0.8, 0.6, 0.5, 0.4, 0.3, 0.2, 0.2, 0.1, 0.1, 0.1, 0.1, 0.1, 0.1, 0.1, 0.1, 0.1}
-	maxYValue := 11.2
-	chartWidget := newConnectionStatsChart("Connction Stats", chartData, maxYValue, "11.2KB/s", "0B/s")
+	chartWidget := newConnectionStatsChart("Connction Stats", chartSampleCapacity)
+	chartWidget.AddSeries("Bytes in", bytesInColor)
+	chartWidget.AddSeries("Bytes out", bytesOutColor)
 	chartContainer := container.NewMax(chartWidget)
+	stackedCheck := widget.NewCheck("Stacked", chartWidget.SetStacked)
 
 	bytesInIcon := widget.NewIcon(theme.MoveDownIcon())
-	bytesInLabel := widget.NewLabel("BYTES IN\n0 KB/S")
+	bytesInLabel := widget.NewLabel("BYTES IN\n" + formatByteRate(0))
 	bytesInSection := container.NewHBox(bytesInIcon, bytesInLabel)
 	bytesOutIcon := widget.NewIcon(theme.MoveUpIcon())
-	bytesOutLabel := widget.NewLabel("BYTES OUT\n0 KB/S")
+	bytesOutLabel := widget.NewLabel("BYTES OUT\n" + formatByteRate(0))
 	bytesOutSection := container.NewHBox(bytesOutIcon, bytesOutLabel)
-	bytesHBox := container.NewHBox(bytesInSection, layout.NewSpacer(), bytesOutSection)
+	bytesHBox := container.NewHBox(bytesInSection, layout.NewSpacer(), stackedCheck, layout.NewSpacer(), bytesOutSection)
+	chartWidget.OnAppend = func(samples []float64) {
+		bytesInLabel.SetText("BYTES IN\n" + formatByteRate(samples[0]))
+		bytesOutLabel.SetText("BYTES OUT\n" + formatByteRate(samples[1]))
+	}
+	go feedChart(chartWidget, stopFeed, demoThroughput(0, 1), demoThroughput(len(demoThroughputKB)/3, 0.35))
 
 	centerContent := container.NewVBox(
 		connectionInfoSection,
@@ -1064,18 +1615,81 @@ func createConnectedScreen(gatewayName, gatewayRegion, deviceName string) fyne.C
 		layout.NewSpacer(),
 	)
 
+	var mainContent fyne.CanvasObject = container.NewPadded(centerContent)
+	if len(route) > 0 {
+		gateway := route[len(route)-1]
+		routeMap := NewTileMapWidget(minZoom, gateway.Lat, gateway.Lon)
+		routeMap.AddMarkers(&MapMarker{ID: gatewayRegion, Lat: gateway.Lat, Lon: gateway.Lon, Name: gatewayName, Region: gatewayRegion})
+		if len(route) >= 2 {
+			accuracy := 0.0
+			if deviceLocation != nil {
+				accuracy = deviceLocation.AccuracyKm * 1000
+			}
+			routeMap.SetUserLocation(route[0], accuracy)
+			routeMap.AddPolyline(&MapPolyline{ID: "route", Points: routePath(route...)})
+			routeMap.FitBounds(latLngBounds(route...), markerHitRadius*2)
+		}
+		routeMap.SetControlsVisible(true)
+		routeMap.SetLocateTarget(gatewayRegion)
+
+		connectedSplit := container.NewHSplit(mainContent, container.NewPadded(routeMap))
+		connectedSplit.Offset = 0.5
+		mainContent = connectedSplit
+	}
+
 	connectedLayout := container.NewBorder(
 		container.NewPadded(topBar),
 		nil, nil, nil,
-		container.NewPadded(centerContent),
+		mainContent,
 	)
 	return connectedLayout
 }
 
+// demoThroughputKB is a recorded throughput curve in KB/s.
+var demoThroughputKB = []float64{0.1, 0.1, 0.2, 0.1, 0.2, 0.3, 0.2, 0.1, 0.1, 0.1, 0.1, 0.1, 0.1, 0.1, 0.1, 0.1, 0.1, 0.2, 0.3, 0.5, 0.8, 1.2, 1.8, 2.5, 3.0, 2.2, 1.5, 0.8, 0.5, 0.3, 0.2, 0.2, 0.2, 0.3, 0.4, 0.8, 1.5, 2.5, 4.0, 6.0, 8.5, 10.0, 11.0, 10.8, 9.5, 8.0, 6.5, 5.5, 4.8, 4.0, 3.5, 3.0, 2.6, 2.2, 1.8, 1.5, 1.2, 1.0, 0.8, 0.6, 0.5, 0.4, 0.3, 0.2, 0.2, 0.1, 0.1, 0.1, 0.1, 0.1, 0.1, 0.1, 0.1, 0.1}
+
+// demoThroughput replays demoThroughputKB in bytes per second, starting at
+// sample offset and multiplied by scale, standing in for the tunnel's traffic
+// counters.
+func demoThroughput(offset int, scale float64) func() float64 {
+	i := offset
+	return func() float64 {
+		v := demoThroughputKB[i%len(demoThroughputKB)] * 1024 * scale
+		i++
+		return v
+	}
+}
+
+// feedChart appends a sample from each of sources, one per series, every
+// chartSampleInterval until stop is closed.
+func feedChart(chart *connectionStatsChart, stop <-chan struct{}, sources ...func() float64) {
+	ticker := time.NewTicker(chartSampleInterval)
+	defer ticker.Stop()
+	samples := make([]float64, len(sources))
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			for i, next := range sources {
+				samples[i] = next()
+			}
+			chart.Append(samples...)
+		}
+	}
+}
+
 func main() {
 	// precache()
 	// deleteCache()
 
+	if len(os.Args) > 1 && os.Args[1] == "staticmap" {
+		if err := runStaticMap(os.Args[2:]); err != nil {
+			log.Fatalf("staticmap: %v", err)
+		}
+		return
+	}
+
 	a := app.New()
 	a.Settings().SetTheme(theme.DarkTheme())
 	err := initTileCache("cache/tiles")
@@ -1083,6 +1697,12 @@ func main() {
 		log.Printf("Warning: Failed to initialize tile cache: %v. Caching disabled.", err)
 		tileCachePath = ""
 	}
+	if loc, err := locateDevice(); err != nil {
+		log.Printf("Warning: Device location unknown: %v", err)
+	} else {
+		deviceLocation = loc
+		log.Printf("Device %s located near %s, %s (±%.0f km)", loc.IP, loc.City, loc.Country, loc.AccuracyKm)
+	}
 	mainWindow = a.NewWindow("Fyne App with Map")
 	loginContent := createLoginScreen()
 	mainWindow.SetContent(loginContent)
@@ -1120,10 +1740,6 @@ func latLonToTileXY(lat, lon float64, zoom int) (float64, float64) {
 	return xtile, ytile
 }
 
-func getTileFilePath(coord TileCoord) string {
-	return filepath.Join(tileCachePath, fmt.Sprintf("%d", coord.Z), fmt.Sprintf("%d", coord.X), fmt.Sprintf("%d.png", coord.Y))
-}
-
 func writeRawTileToCache(filePath string, data []byte) error {
 	cacheWriteMutex.Lock()
 	defer cacheWriteMutex.Unlock()
@@ -1271,7 +1887,7 @@ func precacheLimitedTiles(minLat, maxLat, minLon, maxLon float64, targetZoom int
 
 			}
 
-			filePath := getTileFilePath(c)
+			filePath := getTileFilePath(mapboxDarkStyle.tileCacheDir(), c)
 
 			if _, err := os.Stat(filePath); err == nil {
 				skippedCount.Add(1)
@@ -1367,6 +1983,9 @@ func initTileCache(relativeCacheDir string) error {
 		}
 		return fmt.Errorf("mkdir '%s': %w", appCachePath, err)
 	}
+	if err := migrateTileCache(appCachePath); err != nil {
+		return fmt.Errorf("migrate '%s': %w", appCachePath, err)
+	}
 	tileCachePath = appCachePath
 	log.Println("Using relative tile cache directory:", tileCachePath)
 	return nil
@@ -0,0 +1,273 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/test"
+)
+
+// uiThreadDriver runs fyne.Do calls on a single goroutine, like the real
+// drivers, instead of inline like the test driver.
+type uiThreadDriver struct {
+	fyne.Driver
+	queue chan func()
+}
+
+func (d *uiThreadDriver) DoFromGoroutine(f func(), wait bool) {
+	if !wait {
+		d.queue <- f
+		return
+	}
+	d.run(f)
+}
+
+// run calls f on the UI goroutine and waits for it.
+func (d *uiThreadDriver) run(f func()) {
+	done := make(chan struct{})
+	d.queue <- func() { f(); close(done) }
+	<-done
+}
+
+type uiThreadApp struct {
+	fyne.App
+	driver *uiThreadDriver
+}
+
+func (a *uiThreadApp) Driver() fyne.Driver { return a.driver }
+
+// startUIThread installs a test app whose fyne.Do calls run on one goroutine.
+func startUIThread(t testing.TB) *uiThreadDriver {
+	base := test.NewApp()
+	driver := &uiThreadDriver{Driver: base.Driver(), queue: make(chan func(), 1024)}
+	fyne.SetCurrentApp(&uiThreadApp{App: base, driver: driver})
+	stop := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		for {
+			select {
+			case f := <-driver.queue:
+				f()
+			case <-stop:
+				return
+			}
+		}
+	}()
+	t.Cleanup(func() {
+		close(stop)
+		<-stopped
+		base.Quit()
+	})
+	return driver
+}
+
+// serveTestTiles serves a plain tile for every request after a short delay.
+func serveTestTiles(t testing.TB) MapStyle {
+	tile := image.NewRGBA(image.Rect(0, 0, mapTileSize, mapTileSize))
+	for i := range tile.Pix {
+		tile.Pix[i] = 0x80
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, tile); err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(time.Millisecond)
+		w.Header().Set("Content-Type", "image/png")
+		w.Write(buf.Bytes())
+	}))
+	t.Cleanup(server.Close)
+	return MapStyle{Name: "Test", URLTemplate: server.URL + "/{z}/{x}/{y}.png"}
+}
+
+func useTempTileCache(t testing.TB) {
+	cwd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir, err := filepath.Rel(cwd, t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	previous := tileCachePath
+	if err := initTileCache(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { tileCachePath = previous })
+}
+
+// TestConcurrentDragsAndTileArrivals drags the map on the UI thread while
+// tiles arrive from download goroutines and exports render off the UI
+// thread. Run it with -race.
+func TestConcurrentDragsAndTileArrivals(t *testing.T) {
+	ui := startUIThread(t)
+	useTempTileCache(t)
+	style := serveTestTiles(t)
+
+	var m *TileMapWidget
+	var w fyne.Window
+	ui.run(func() {
+		m = NewTileMapWidget(7, 12, 112)
+		m.SetMapStyle(style)
+		m.AddMarkers(&MapMarker{ID: "a", Lat: 12, Lon: 112})
+		m.AddLayer(NewTileLayer("overlay", style.URLTemplate, 0.5))
+		w = test.NewWindow(m)
+		w.Resize(fyne.NewSize(800, 600))
+	})
+	store := style.tileStore()
+
+	var wg sync.WaitGroup
+	deadline := time.Now().Add(2 * time.Second)
+	// Tile events from several fetch goroutines, besides the real downloads.
+	blank := image.NewUniform(color.Gray{Y: 0x80})
+	for g := 0; g < 4; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; time.Now().Before(deadline); i++ {
+				store.finish(tileLoad{coord: TileCoord{Z: 7, X: 90 + (i+g)%30, Y: 50 + i%20}, requestedAt: time.Now()}, TileLoaded, blank, nil)
+				time.Sleep(time.Millisecond)
+			}
+		}(g)
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for time.Now().Before(deadline) {
+			if _, err := m.RenderImage(400, 300); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+	}()
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; time.Now().Before(deadline); i++ {
+			d := float32(8)
+			if i%100 >= 50 {
+				d = -8
+			}
+			ui.run(func() {
+				m.Dragged(&fyne.DragEvent{Dragged: fyne.NewDelta(d, d/2)})
+				if i%10 == 0 {
+					m.DragEnd()
+					w.Canvas().Capture()
+				}
+			})
+		}
+	}()
+	wg.Wait()
+
+	ui.run(func() {
+		if _, err := m.RenderImage(400, 300); err != nil {
+			t.Error(err)
+		}
+		w.Close()
+	})
+	if stats := store.stats(); stats.downloads == 0 {
+		t.Errorf("no tiles were downloaded: %+v", stats)
+	}
+}
+
+func TestSubscribeTileEvents(t *testing.T) {
+	m := NewTileMapWidget(7, 12, 112)
+	var mu sync.Mutex
+	counts := map[string]int{}
+	count := func(name string) func(TileEvent) {
+		return func(TileEvent) { mu.Lock(); counts[name]++; mu.Unlock() }
+	}
+	ev := TileEvent{Coord: TileCoord{Z: 7}, Kind: TileRequested}
+
+	unsubscribeA := m.SubscribeTileEvents(count("a"))
+	unsubscribeB := m.SubscribeTileEvents(count("b"))
+	m.handleTileEvent(ev)
+	unsubscribeA()
+	unsubscribeA()
+	m.handleTileEvent(ev)
+	if counts["a"] != 1 || counts["b"] != 2 {
+		t.Errorf("got %v, want a=1 b=2", counts)
+	}
+	unsubscribeB()
+
+	// Subscribing while events arrive from other goroutines; run with -race.
+	var wg sync.WaitGroup
+	for g := 0; g < 4; g++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 200; i++ {
+				m.handleTileEvent(ev)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 200; i++ {
+				m.SubscribeTileEvents(count("c"))()
+			}
+		}()
+	}
+	wg.Wait()
+	if counts["a"] != 1 || counts["b"] != 2 {
+		t.Errorf("unsubscribed handlers still called: %v", counts)
+	}
+}
+
+// BenchmarkMapRefreshDuringDrag measures one 3 px drag step of a map showing
+// tiles, the latency heatmap, GeoJSON regions and markers: the view update and
+// the renderer refresh on the UI thread, without painting.
+func BenchmarkMapRefreshDuringDrag(b *testing.B) {
+	ui := startUIThread(b)
+	useTempTileCache(b)
+	style := serveTestTiles(b)
+
+	const steps, step = 100, 3
+	var m *TileMapWidget
+	var heatmap *HeatmapLayer
+	ui.run(func() {
+		m = NewTileMapWidget(7, 12, 112)
+		m.SetMapStyle(style)
+		m.AddMarkers(&MapMarker{ID: "a", Lat: 10.77, Lon: 106.7}, &MapMarker{ID: "b", Lat: 13.75, Lon: 100.5})
+		if err := m.AddGeoJSON("regions", regionsGeoJSON, GeoJSONStyle{}); err != nil {
+			b.Fatal(err)
+		}
+		heatmap = NewHeatmapLayer("latency", 0.55)
+		heatmap.SetSamples(latencySamples...)
+		m.AddLayer(heatmap)
+		w := test.NewWindow(m)
+		w.SetPadded(false)
+		w.Resize(fyne.NewSize(1000, 700))
+		b.Cleanup(w.Close)
+	})
+
+	// Load every tile the drag passes over, and the heatmap, before timing.
+	reach := float32(2 * steps * step)
+	area := mapView{zoom: 7, centerLat: 12, centerLon: 112, width: 1000 + reach, height: 700 + reach}
+	style.tileStore().await(area.visibleTiles(), time.Now().Add(10*time.Second))
+	for ready := false; !ready; time.Sleep(time.Millisecond) {
+		ui.run(func() {
+			m.Refresh()
+			ready = heatmap.field.cells != nil
+		})
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		d := float32(step)
+		if i%(2*steps) >= steps {
+			d = -step
+		}
+		ui.run(func() { m.Dragged(&fyne.DragEvent{Dragged: fyne.NewDelta(d, d)}) })
+	}
+}
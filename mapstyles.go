@@ -0,0 +1,188 @@
+package main
+
+import (
+	"fmt"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/theme"
+	"fyne.io/fyne/v2/widget"
+)
+
+const automaticStyleName = "Automatic"
+
+// MapStyle is a base map the map can draw: a Mapbox style, or any other
+// provider's tiles.
+type MapStyle struct {
+	Name string
+	// Owner and StyleID name a Mapbox style.
+	Owner   string
+	StyleID string
+	// URLTemplate, if set, loads tiles from another provider instead. It may
+	// contain {z}, {x}, {y} and {size} placeholders.
+	URLTemplate string
+	// Attribution credits the provider. Mapbox styles default to the Mapbox credits.
+	Attribution []AttributionLink
+}
+
+var (
+	mapboxDarkStyle      = MapStyle{Name: "Dark", Owner: mapboxUsername, StyleID: mapboxStyleID}
+	mapboxLightStyle     = MapStyle{Name: "Light", Owner: "mapbox", StyleID: "light-v11"}
+	mapboxStreetsStyle   = MapStyle{Name: "Streets", Owner: "mapbox", StyleID: "streets-v12"}
+	mapboxSatelliteStyle = MapStyle{Name: "Satellite", Owner: "mapbox", StyleID: "satellite-streets-v12"}
+	openStreetMapStyle   = MapStyle{
+		Name:        "OpenStreetMap",
+		URLTemplate: "https://tile.openstreetmap.org/{z}/{x}/{y}.png",
+		Attribution: []AttributionLink{{Text: "© OpenStreetMap", URL: "https://www.openstreetmap.org/copyright"}},
+	}
+)
+
+// mapStyleChoices are the styles offered by the style picker.
+var mapStyleChoices = []MapStyle{mapboxStreetsStyle, mapboxSatelliteStyle, mapboxDarkStyle, mapboxLightStyle, openStreetMapStyle}
+
+func (s MapStyle) isZero() bool {
+	return s.StyleID == "" && s.URLTemplate == ""
+}
+
+// same reports whether s and other draw the same tiles.
+func (s MapStyle) same(other MapStyle) bool {
+	return s.urlTemplate() == other.urlTemplate()
+}
+
+func (s MapStyle) urlTemplate() string {
+	if s.URLTemplate != "" {
+		return s.URLTemplate
+	}
+	return fmt.Sprintf("https://api.mapbox.com/styles/v1/%s/%s/tiles/{size}/{z}/{x}/{y}?access_token=%s", s.Owner, s.StyleID, mapboxAccessToken)
+}
+
+func (s MapStyle) attribution() []AttributionLink {
+	if s.Attribution != nil || s.URLTemplate != "" {
+		return s.Attribution
+	}
+	return mapboxAttribution
+}
+
+// tileStore returns the shared store for the style. Each style has its own
+// memory and disk cache.
+func (s MapStyle) tileStore() *tileStore {
+	return sharedTileStore(s.urlTemplate(), s.tileCacheDir())
+}
+
+// SetMapStyles sets the base map styles drawn under light and dark app
+// themes. Unless a style is chosen with SetMapStyle, the map follows theme
+// changes by itself.
+func (m *TileMapWidget) SetMapStyles(light, dark MapStyle) {
+	m.mu.Lock()
+	m.lightStyle, m.darkStyle = light, dark
+	m.mu.Unlock()
+	m.Refresh()
+}
+
+// SetMapStyle switches the base map to style, keeping the view. The zero
+// MapStyle goes back to following the app theme.
+func (m *TileMapWidget) SetMapStyle(style MapStyle) {
+	m.mu.Lock()
+	m.chosenStyle = style
+	m.mu.Unlock()
+	m.Refresh()
+}
+
+// MapStyle returns the style the base layer currently draws.
+func (m *TileMapWidget) MapStyle() MapStyle {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.style
+}
+
+// wantedMapStyle is the chosen style, or else the one for the theme variant.
+func (m *TileMapWidget) wantedMapStyle() MapStyle {
+	variant := currentThemeVariant()
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	switch {
+	case !m.chosenStyle.isZero():
+		return m.chosenStyle
+	case variant == theme.VariantLight:
+		return m.lightStyle
+	default:
+		return m.darkStyle
+	}
+}
+
+// isThemeStyle reports whether style is one the map uses for a theme variant.
+func (m *TileMapWidget) isThemeStyle(style MapStyle) bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return style.same(m.lightStyle) || style.same(m.darkStyle)
+}
+
+// syncMapStyle swaps the base layer when the wanted style changed, and
+// reports whether it did. It runs on the UI thread before each redraw. The
+// tiles of a style no map draws any more are dropped from memory, except
+// for the theme styles, which stay loaded for quick theme switches.
+func (m *TileMapWidget) syncMapStyle() bool {
+	source := m
+	if m.styleSource != nil {
+		source = m.styleSource
+	}
+	style := source.wantedMapStyle()
+
+	m.mu.Lock()
+	old := m.baseLayer
+	if old == nil || style.same(m.style) {
+		m.mu.Unlock()
+		return false
+	}
+	oldStyle := m.style
+	next := newTileLayer(old.id, style.tileStore(), old.Opacity())
+	next.placeholders = old.placeholders
+	next.attribution = style.attribution()
+	next.attach(m)
+	for i, layer := range m.layers {
+		if layer == MapLayer(old) {
+			m.layers[i] = next
+		}
+	}
+	m.baseLayer, m.style = next, style
+	subscribed := m.subscribed
+	if subscribed {
+		next.store.subscribe(m)
+	}
+	m.mu.Unlock()
+
+	old.stopFades()
+	if subscribed && old.store.unsubscribe(m) == 0 && !source.isThemeStyle(oldStyle) {
+		old.store.release()
+	}
+	return true
+}
+
+// currentThemeVariant tells light from dark themes by their background, which
+// also works for themes that force a variant, like theme.DarkTheme.
+func currentThemeVariant() fyne.ThemeVariant {
+	r, g, b, _ := theme.Color(theme.ColorNameBackground).RGBA()
+	if (299*r+587*g+114*b)/1000 < 0x8000 {
+		return theme.VariantDark
+	}
+	return theme.VariantLight
+}
+
+// newMapStylePicker returns a select that switches m between styles. Its
+// first option follows the app theme.
+func newMapStylePicker(m *TileMapWidget, styles []MapStyle) *widget.Select {
+	options := []string{automaticStyleName}
+	for _, style := range styles {
+		options = append(options, style.Name)
+	}
+	picker := widget.NewSelect(options, func(name string) {
+		for _, style := range styles {
+			if style.Name == name {
+				m.SetMapStyle(style)
+				return
+			}
+		}
+		m.SetMapStyle(MapStyle{})
+	})
+	picker.SetSelected(automaticStyleName)
+	return picker
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"image/color"
+	"math"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/canvas"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/widget"
+)
+
+const (
+	viewportLayerID = "viewport"
+
+	minViewportSize = 6
+)
+
+var (
+	minimapSize         = fyne.NewSize(192, 128)
+	viewportFillColor   = color.NRGBA{R: 255, G: 140, B: 0, A: 48}
+	viewportStrokeColor = color.NRGBA{R: 255, G: 140, B: 0, A: 255}
+	minimapBorderColor  = color.NRGBA{R: 0, G: 0, B: 0, A: 160}
+	minimapHiddenLayers = []string{scaleBarLayerID, attributionLayerID, controlsLayerID}
+)
+
+// MiniMapWidget is an overview inset for a TileMapWidget. It shows a fixed
+// low-zoom view with a rectangle around the main map's viewport; dragging the
+// inset pans the main map and tapping it centers the main map there. The
+// inset draws the main map's base style from the same tile store, so both
+// maps share one cache.
+type MiniMapWidget struct {
+	widget.BaseWidget
+	mainMap  *TileMapWidget
+	overview *TileMapWidget
+}
+
+// NewMiniMapWidget creates an inset for mainMap showing the area around center at zoom.
+func NewMiniMapWidget(mainMap *TileMapWidget, center LatLng, zoom int) *MiniMapWidget {
+	overview := NewTileMapWidget(zoom, center.Lat, center.Lon)
+	overview.SetZoomRange(zoom, zoom)
+	style := mainMap.MapStyle()
+	base := newTileLayer(baseLayerID, style.tileStore(), 1)
+	base.attribution = style.attribution()
+	overview.AddLayer(base)
+	overview.mu.Lock()
+	overview.baseLayer, overview.style, overview.styleSource = base, style, mainMap
+	overview.mu.Unlock()
+	overview.AddLayer(&viewportLayer{mainMap: mainMap})
+	for _, id := range minimapHiddenLayers {
+		overview.SetLayerVisible(id, false)
+	}
+
+	mini := &MiniMapWidget{mainMap: mainMap, overview: overview}
+	mini.ExtendBaseWidget(mini)
+	overview.OnDragged = mini.dragged
+	overview.OnMapTapped = mini.tapped
+	mainMap.watchView(func(mapView) { overview.Refresh() })
+	return mini
+}
+
+func (mini *MiniMapWidget) CreateRenderer() fyne.WidgetRenderer {
+	border := canvas.NewRectangle(color.Transparent)
+	border.StrokeColor = minimapBorderColor
+	border.StrokeWidth = 1
+	return widget.NewSimpleRenderer(container.NewStack(mini.overview, border))
+}
+
+func (mini *MiniMapWidget) MinSize() fyne.Size {
+	return minimapSize
+}
+
+// dragged moves the main map by the drag distance measured at the inset's scale.
+func (mini *MiniMapWidget) dragged(e *fyne.DragEvent) {
+	mainCenter, mainZoom := mini.mainMap.View()
+	view := mini.overview.currentView()
+	x, y := view.project(mainCenter.Lat, mainCenter.Lon)
+	mini.mainMap.SetView(view.unproject(x+e.Dragged.DX, y+e.Dragged.DY), mainZoom)
+}
+
+func (mini *MiniMapWidget) tapped(pos LatLng) {
+	_, mainZoom := mini.mainMap.View()
+	mini.mainMap.SetView(pos, mainZoom)
+}
+
+// viewportLayer outlines the main map's visible area on the overview.
+type viewportLayer struct {
+	mainMap *TileMapWidget
+	frame   *canvas.Rectangle
+}
+
+func (l *viewportLayer) LayerID() string { return viewportLayerID }
+
+func (l *viewportLayer) attach(m *TileMapWidget) {
+	l.frame = canvas.NewRectangle(viewportFillColor)
+	l.frame.StrokeColor = viewportStrokeColor
+	l.frame.StrokeWidth = 2
+}
+
+func (l *viewportLayer) refresh(view mapView) []fyne.CanvasObject {
+	mainView := l.mainMap.currentView()
+	if mainView.width <= 0 || mainView.height <= 0 {
+		return nil
+	}
+	corners := view.projectPath([]LatLng{
+		mainView.unproject(0, 0),
+		mainView.unproject(mainView.width, mainView.height),
+	})
+	x0, y0 := corners[0].X, corners[0].Y
+	w := float32(math.Max(float64(corners[1].X-x0), minViewportSize))
+	h := float32(math.Max(float64(corners[1].Y-y0), minViewportSize))
+	midX, midY := (x0+corners[1].X)/2, (y0+corners[1].Y)/2
+
+	l.frame.Resize(fyne.NewSize(w, h))
+	l.frame.Move(fyne.NewPos(midX-w/2, midY-h/2))
+	l.frame.Refresh()
+	return []fyne.CanvasObject{l.frame}
+}
@@ -0,0 +1,279 @@
+package main
+
+import (
+	"fmt"
+	"image/color"
+	"math"
+	"net/url"
+	"strings"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/canvas"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/theme"
+	"fyne.io/fyne/v2/widget"
+)
+
+const (
+	scaleBarLayerID    = "scale"
+	attributionLayerID = "attribution"
+
+	scaleBarMaxWidth   = 100
+	earthCircumference = 40075016.686
+	metersPerFoot      = 0.3048
+	metersPerMile      = 1609.344
+)
+
+var overlayBackgroundColor = color.NRGBA{R: 0, G: 0, B: 0, A: 140}
+
+type ScaleUnits int
+
+const (
+	ScaleUnitsBoth ScaleUnits = iota
+	ScaleUnitsMetric
+	ScaleUnitsImperial
+)
+
+// AttributionLink is one credit shown in the attribution box.
+type AttributionLink struct {
+	Text string
+	URL  string
+}
+
+var mapboxAttribution = []AttributionLink{
+	{Text: "© Mapbox", URL: "https://www.mapbox.com/about/maps/"},
+	{Text: "© OpenStreetMap", URL: "https://www.openstreetmap.org/copyright"},
+	{Text: "Improve this map", URL: "https://www.mapbox.com/map-feedback/"},
+}
+
+// SetScaleBarUnits chooses which scale bars are drawn.
+func (m *TileMapWidget) SetScaleBarUnits(units ScaleUnits) {
+	m.mu.Lock()
+	m.scaleUnits = units
+	m.mu.Unlock()
+	m.Refresh()
+}
+
+// attributions collects the credits of all visible tile layers, without duplicates.
+func (m *TileMapWidget) attributions() []AttributionLink {
+	m.mu.RLock()
+	layers := make([]*TileLayer, 0, len(m.layers))
+	for _, layer := range m.layers {
+		if tiles, ok := layer.(*TileLayer); ok && !m.hiddenLayers[tiles.id] {
+			layers = append(layers, tiles)
+		}
+	}
+	m.mu.RUnlock()
+
+	seen := make(map[AttributionLink]bool)
+	links := make([]AttributionLink, 0)
+	for _, layer := range layers {
+		for _, link := range layer.Attribution() {
+			if !seen[link] {
+				seen[link] = true
+				links = append(links, link)
+			}
+		}
+	}
+	return links
+}
+
+// =====================================================
+// Scale Bar
+// =====================================================
+
+// scaleBarOverlay draws metric and/or imperial scale bars in the bottom-left corner.
+type scaleBarOverlay struct {
+	mapWidget  *TileMapWidget
+	background *canvas.Rectangle
+	bars       [2]*scaleBarRow
+}
+
+type scaleBarRow struct {
+	line  *canvas.Line
+	left  *canvas.Line
+	right *canvas.Line
+	label *canvas.Text
+}
+
+func newScaleBarOverlay() *scaleBarOverlay {
+	o := &scaleBarOverlay{background: canvas.NewRectangle(overlayBackgroundColor)}
+	for i := range o.bars {
+		row := &scaleBarRow{
+			line:  canvas.NewLine(color.White),
+			left:  canvas.NewLine(color.White),
+			right: canvas.NewLine(color.White),
+			label: canvas.NewText("", color.White),
+		}
+		row.line.StrokeWidth, row.left.StrokeWidth, row.right.StrokeWidth = 2, 2, 2
+		row.label.TextSize = theme.CaptionTextSize()
+		o.bars[i] = row
+	}
+	return o
+}
+
+func (o *scaleBarOverlay) LayerID() string { return scaleBarLayerID }
+
+func (o *scaleBarOverlay) attach(m *TileMapWidget) { o.mapWidget = m }
+
+func (o *scaleBarOverlay) refresh(view mapView) []fyne.CanvasObject {
+	o.mapWidget.mu.RLock()
+	units := o.mapWidget.scaleUnits
+	o.mapWidget.mu.RUnlock()
+
+	bars := scaleBars(view, units)
+	if len(bars) == 0 {
+		return nil
+	}
+
+	padding := theme.Padding()
+	rowHeight := o.bars[0].label.MinSize().Height + 4
+	boxH := rowHeight*float32(len(bars)) + padding
+	x := padding * 2
+	y := view.height - boxH - padding*2
+
+	objects := []fyne.CanvasObject{o.background}
+	boxW := float32(0)
+	for i, b := range bars {
+		row := o.bars[i]
+		rowY := y + padding/2 + rowHeight*float32(i)
+		barY := rowY + rowHeight/2 + 2
+		row.line.Position1 = fyne.NewPos(x+padding, barY)
+		row.line.Position2 = fyne.NewPos(x+padding+b.width, barY)
+		row.left.Position1 = fyne.NewPos(x+padding, barY-4)
+		row.left.Position2 = fyne.NewPos(x+padding, barY)
+		row.right.Position1 = fyne.NewPos(x+padding+b.width, barY-4)
+		row.right.Position2 = fyne.NewPos(x+padding+b.width, barY)
+		row.label.Text = b.label
+		row.label.Refresh()
+		labelSize := row.label.MinSize()
+		row.label.Resize(labelSize)
+		row.label.Move(fyne.NewPos(x+padding*2+b.width, rowY))
+		for _, line := range []*canvas.Line{row.line, row.left, row.right} {
+			line.Refresh()
+			objects = append(objects, line)
+		}
+		objects = append(objects, row.label)
+		boxW = float32(math.Max(float64(boxW), float64(padding*3+b.width+labelSize.Width)))
+	}
+	o.background.Resize(fyne.NewSize(boxW, boxH))
+	o.background.Move(fyne.NewPos(x, y))
+	return objects
+}
+
+type scaleBar struct {
+	width float32
+	label string
+}
+
+// scaleBars returns the metric and/or imperial bars for view, each at most
+// scaleBarMaxWidth pixels long.
+func scaleBars(view mapView, units ScaleUnits) []scaleBar {
+	metersPerPixel := metersPerPixelAt(view.centerLat, view.zoom)
+	if metersPerPixel <= 0 {
+		return nil
+	}
+	maxMeters := metersPerPixel * scaleBarMaxWidth
+
+	bars := make([]scaleBar, 0, 2)
+	if units != ScaleUnitsImperial {
+		meters := niceScaleValue(maxMeters)
+		label := fmt.Sprintf("%g m", meters)
+		if meters >= 1000 {
+			label = fmt.Sprintf("%g km", meters/1000)
+		}
+		bars = append(bars, scaleBar{width: float32(meters / metersPerPixel), label: label})
+	}
+	if units != ScaleUnitsMetric {
+		maxFeet := maxMeters / metersPerFoot
+		if maxFeet < 5280 {
+			feet := niceScaleValue(maxFeet)
+			bars = append(bars, scaleBar{width: float32(feet * metersPerFoot / metersPerPixel), label: fmt.Sprintf("%g ft", feet)})
+		} else {
+			miles := niceScaleValue(maxMeters / metersPerMile)
+			bars = append(bars, scaleBar{width: float32(miles * metersPerMile / metersPerPixel), label: fmt.Sprintf("%g mi", miles)})
+		}
+	}
+	return bars
+}
+
+// metersPerPixelAt is the ground resolution of a Web Mercator map at lat.
+func metersPerPixelAt(lat float64, zoom int) float64 {
+	return earthCircumference * math.Cos(lat*math.Pi/180.0) / (mapTileSize * math.Pow(2.0, float64(zoom)))
+}
+
+// niceScaleValue rounds v down to 1, 2 or 5 times a power of ten.
+func niceScaleValue(v float64) float64 {
+	if v <= 0 {
+		return 0
+	}
+	pow := math.Pow(10, math.Floor(math.Log10(v)))
+	for _, step := range []float64{5, 2, 1} {
+		if step*pow <= v {
+			return step * pow
+		}
+	}
+	return pow
+}
+
+// =====================================================
+// Attribution
+// =====================================================
+
+// attributionOverlay shows the visible tile providers' credits as links in
+// the bottom-right corner.
+type attributionOverlay struct {
+	mapWidget  *TileMapWidget
+	background *canvas.Rectangle
+	box        *fyne.Container
+	key        string
+}
+
+func newAttributionOverlay() *attributionOverlay {
+	return &attributionOverlay{
+		background: canvas.NewRectangle(overlayBackgroundColor),
+		box:        container.NewHBox(),
+	}
+}
+
+func (o *attributionOverlay) LayerID() string { return attributionLayerID }
+
+func (o *attributionOverlay) attach(m *TileMapWidget) { o.mapWidget = m }
+
+func (o *attributionOverlay) refresh(view mapView) []fyne.CanvasObject {
+	links := o.mapWidget.attributions()
+	if len(links) == 0 {
+		return nil
+	}
+
+	keys := make([]string, len(links))
+	for i, link := range links {
+		keys[i] = link.Text + "|" + link.URL
+	}
+	if key := strings.Join(keys, "\n"); key != o.key {
+		o.key = key
+		o.box.Objects = o.box.Objects[:0]
+		for _, link := range links {
+			var obj fyne.CanvasObject
+			if u, err := url.Parse(link.URL); err == nil && link.URL != "" {
+				hyperlink := widget.NewHyperlink(link.Text, u)
+				hyperlink.SizeName = theme.SizeNameCaptionText
+				obj = hyperlink
+			} else {
+				label := canvas.NewText(link.Text, color.White)
+				label.TextSize = theme.CaptionTextSize()
+				obj = label
+			}
+			o.box.Objects = append(o.box.Objects, obj)
+		}
+		o.box.Refresh()
+	}
+
+	size := o.box.MinSize()
+	pos := fyne.NewPos(view.width-size.Width, view.height-size.Height)
+	o.box.Resize(size)
+	o.box.Move(pos)
+	o.background.Resize(size)
+	o.background.Move(pos)
+	return []fyne.CanvasObject{o.background, o.box}
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"image/color"
+	"math"
+	"sync"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/canvas"
+)
+
+const (
+	polylineDefaultWidth = 2
+	greatCircleSegments  = 64
+)
+
+var mapRouteColor = color.NRGBA{R: 0, G: 200, B: 120, A: 255}
+
+// MapPolyline is a geographic line string drawn over the tiles. Longitudes may
+// run past ±180 so that lines crossing the antimeridian stay continuous.
+type MapPolyline struct {
+	ID     string
+	Points []LatLng
+	Color  color.Color
+	Width  float32
+}
+
+// VectorLayer draws polylines and GeoJSON overlays above the raster layers.
+type VectorLayer struct {
+	id string
+
+	mu        sync.RWMutex
+	polylines []*MapPolyline
+	geoJSON   []*geoJSONOverlay
+	mapWidget *TileMapWidget
+
+	canvasLines   map[string][]*canvas.Line
+	geoFills      []geoFill
+	geoFillRaster *canvas.Raster
+	geoPoints     []*canvas.Circle
+	viewWidth     float32
+}
+
+func NewVectorLayer(id string) *VectorLayer {
+	return &VectorLayer{
+		id:          id,
+		canvasLines: make(map[string][]*canvas.Line),
+	}
+}
+
+func (l *VectorLayer) LayerID() string { return l.id }
+
+func (l *VectorLayer) attach(m *TileMapWidget) {
+	l.mu.Lock()
+	l.mapWidget = m
+	l.mu.Unlock()
+}
+
+func (l *VectorLayer) changed() {
+	l.mu.RLock()
+	m := l.mapWidget
+	l.mu.RUnlock()
+	if m != nil {
+		m.Refresh()
+	}
+}
+
+// AddPolyline adds a polyline, replacing any existing polyline with the same ID.
+func (l *VectorLayer) AddPolyline(line *MapPolyline) {
+	if line == nil || line.ID == "" {
+		return
+	}
+	l.mu.Lock()
+	replaced := false
+	for i, existing := range l.polylines {
+		if existing.ID == line.ID {
+			l.polylines[i] = line
+			replaced = true
+			break
+		}
+	}
+	if !replaced {
+		l.polylines = append(l.polylines, line)
+	}
+	l.mu.Unlock()
+	l.changed()
+}
+
+func (l *VectorLayer) RemovePolyline(id string) bool {
+	l.mu.Lock()
+	removed := false
+	for i, existing := range l.polylines {
+		if existing.ID == id {
+			l.polylines = append(l.polylines[:i], l.polylines[i+1:]...)
+			removed = true
+			break
+		}
+	}
+	l.mu.Unlock()
+	if removed {
+		l.changed()
+	}
+	return removed
+}
+
+func (l *VectorLayer) ClearPolylines() {
+	l.mu.Lock()
+	l.polylines = nil
+	l.mu.Unlock()
+	l.changed()
+}
+
+// AddPolyline adds a polyline to the map's default vector layer.
+func (m *TileMapWidget) AddPolyline(line *MapPolyline) {
+	m.vectorLayer.AddPolyline(line)
+}
+
+func (m *TileMapWidget) RemovePolyline(id string) bool {
+	return m.vectorLayer.RemovePolyline(id)
+}
+
+func (m *TileMapWidget) ClearPolylines() {
+	m.vectorLayer.ClearPolylines()
+}
+
+func (l *VectorLayer) refresh(view mapView) []fyne.CanvasObject {
+	l.mu.RLock()
+	polylines := make([]*MapPolyline, len(l.polylines))
+	copy(polylines, l.polylines)
+	overlays := make([]*geoJSONOverlay, len(l.geoJSON))
+	copy(overlays, l.geoJSON)
+	l.mu.RUnlock()
+
+	fill, outlines, points := l.refreshGeoJSON(overlays, view)
+	objects := make([]fyne.CanvasObject, 0)
+	if fill != nil {
+		objects = append(objects, fill)
+	}
+	objects = append(objects, l.refreshPolylines(append(outlines, polylines...), view)...)
+	return append(objects, points...)
+}
+
+// refreshPolylines positions the line segments of every polyline for the
+// current view, reusing canvas lines keyed by polyline ID.
+func (l *VectorLayer) refreshPolylines(polylines []*MapPolyline, view mapView) []fyne.CanvasObject {
+	objects := make([]fyne.CanvasObject, 0)
+	active := make(map[string]bool, len(polylines))
+
+	for _, line := range polylines {
+		if len(line.Points) < 2 {
+			continue
+		}
+		lineColor := line.Color
+		if lineColor == nil {
+			lineColor = mapRouteColor
+		}
+		width := line.Width
+		if width <= 0 {
+			width = polylineDefaultWidth
+		}
+
+		screenPts := view.projectPath(line.Points)
+		segments := l.canvasLines[line.ID]
+		for len(segments) < len(screenPts)-1 {
+			segments = append(segments, canvas.NewLine(lineColor))
+		}
+		segments = segments[:len(screenPts)-1]
+		for i, seg := range segments {
+			seg.StrokeColor = lineColor
+			seg.StrokeWidth = width
+			seg.Position1 = screenPts[i]
+			seg.Position2 = screenPts[i+1]
+			seg.Show()
+			seg.Refresh()
+			objects = append(objects, seg)
+		}
+		l.canvasLines[line.ID] = segments
+		active[line.ID] = true
+	}
+
+	for id := range l.canvasLines {
+		if !active[id] {
+			delete(l.canvasLines, id)
+		}
+	}
+	return objects
+}
+
+// greatCirclePath interpolates the great-circle arc between from and to.
+// Longitudes are unwrapped so that consecutive points never jump by 360°.
+func greatCirclePath(from, to LatLng, segments int) []LatLng {
+	if segments < 1 {
+		segments = 1
+	}
+	lat1, lon1 := from.Lat*math.Pi/180, from.Lon*math.Pi/180
+	lat2, lon2 := to.Lat*math.Pi/180, to.Lon*math.Pi/180
+
+	d := centralAngle(from, to)
+	if d == 0 {
+		return []LatLng{from, to}
+	}
+
+	points := make([]LatLng, 0, segments+1)
+	prevLon := from.Lon
+	for i := 0; i <= segments; i++ {
+		f := float64(i) / float64(segments)
+		a := math.Sin((1-f)*d) / math.Sin(d)
+		b := math.Sin(f*d) / math.Sin(d)
+		x := a*math.Cos(lat1)*math.Cos(lon1) + b*math.Cos(lat2)*math.Cos(lon2)
+		y := a*math.Cos(lat1)*math.Sin(lon1) + b*math.Cos(lat2)*math.Sin(lon2)
+		z := a*math.Sin(lat1) + b*math.Sin(lat2)
+		lat := math.Atan2(z, math.Sqrt(x*x+y*y)) * 180 / math.Pi
+		lon := math.Atan2(y, x) * 180 / math.Pi
+		lon += math.Round((prevLon-lon)/360.0) * 360.0
+		points = append(points, LatLng{Lat: lat, Lon: lon})
+		prevLon = lon
+	}
+	return points
+}
+
+// centralAngle is the great-circle angle between a and b in radians.
+func centralAngle(a, b LatLng) float64 {
+	lat1, lon1 := a.Lat*math.Pi/180, a.Lon*math.Pi/180
+	lat2, lon2 := b.Lat*math.Pi/180, b.Lon*math.Pi/180
+	return 2 * math.Asin(math.Sqrt(math.Pow(math.Sin((lat2-lat1)/2), 2)+
+		math.Cos(lat1)*math.Cos(lat2)*math.Pow(math.Sin((lon2-lon1)/2), 2)))
+}
+
+// distanceMeters is the great-circle distance between a and b.
+func distanceMeters(a, b LatLng) float64 {
+	return centralAngle(a, b) * earthCircumference / (2 * math.Pi)
+}
+
+// routePath joins great-circle arcs through each consecutive pair of stops.
+func routePath(stops ...LatLng) []LatLng {
+	if len(stops) < 2 {
+		return stops
+	}
+	path := []LatLng{stops[0]}
+	for i := 1; i < len(stops); i++ {
+		arc := greatCirclePath(stops[i-1], stops[i], greatCircleSegments)
+		shift := path[len(path)-1].Lon - arc[0].Lon
+		for _, p := range arc[1:] {
+			path = append(path, LatLng{Lat: p.Lat, Lon: p.Lon + shift})
+		}
+	}
+	return path
+}
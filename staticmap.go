@@ -0,0 +1,157 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"fyne.io/fyne/v2/app"
+	"fyne.io/fyne/v2/theme"
+)
+
+const (
+	staticMapMinZoom = 0
+	staticMapMaxZoom = 18
+	staticMapPadding = 32
+)
+
+// runStaticMap renders a PNG map without starting the GUI:
+//
+//	Cb-UI staticmap -center 10.77,106.70 -zoom 7 -size 800x600 -marker 10.77,106.70 -out map.png
+//	Cb-UI staticmap -bbox 98,5,110,22 -geojson regions.geojson -out region.png
+//
+// Without -center or -bbox the map is framed around the markers and GeoJSON.
+func runStaticMap(args []string) error {
+	fs := flag.NewFlagSet("staticmap", flag.ContinueOnError)
+	center := fs.String("center", "", "map center as `lat,lon`")
+	zoom := fs.Int("zoom", minZoom, "zoom level used with -center")
+	bbox := fs.String("bbox", "", "area to frame as `minLon,minLat,maxLon,maxLat`")
+	size := fs.String("size", "800x600", "image size as `WIDTHxHEIGHT`")
+	geoJSONPath := fs.String("geojson", "", "GeoJSON `file` to draw over the tiles")
+	out := fs.String("out", "staticmap.png", "output PNG `file`")
+	cacheDir := fs.String("cache", "cache/tiles", "tile cache `dir`, relative to the working directory")
+	var markers []LatLng
+	fs.Func("marker", "marker at `lat,lon` (repeatable)", func(v string) error {
+		pos, err := parseLatLng(v)
+		if err == nil {
+			markers = append(markers, pos)
+		}
+		return err
+	})
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	width, height, err := parseImageSize(*size)
+	if err != nil {
+		return err
+	}
+	// Widgets read theme colors and text sizes through the current app. It
+	// never runs, so no window or display is needed.
+	app.New().Settings().SetTheme(theme.DarkTheme())
+	if err := initTileCache(*cacheDir); err != nil {
+		return fmt.Errorf("failed to initialize tile cache: %w", err)
+	}
+
+	m := NewTileMapWidget(*zoom, 0, 0)
+	m.SetZoomRange(staticMapMinZoom, staticMapMaxZoom)
+	frame := make([]LatLng, 0, len(markers))
+	for i, pos := range markers {
+		m.AddMarkers(&MapMarker{ID: fmt.Sprintf("marker-%d", i+1), Lat: pos.Lat, Lon: pos.Lon})
+		frame = append(frame, pos)
+	}
+	if *geoJSONPath != "" {
+		data, err := os.ReadFile(*geoJSONPath)
+		if err != nil {
+			return fmt.Errorf("failed to read GeoJSON: %w", err)
+		}
+		overlay, err := parseGeoJSONOverlay("geojson", data, GeoJSONStyle{})
+		if err != nil {
+			return fmt.Errorf("failed to parse '%s': %w", *geoJSONPath, err)
+		}
+		if err := m.AddGeoJSON("geojson", data, GeoJSONStyle{}); err != nil {
+			return err
+		}
+		frame = append(frame, overlay.positions()...)
+	}
+
+	switch {
+	case *center != "":
+		pos, err := parseLatLng(*center)
+		if err != nil {
+			return fmt.Errorf("-center: %w", err)
+		}
+		m.SetView(pos, *zoom)
+	case *bbox != "":
+		bounds, err := parseBBox(*bbox)
+		if err != nil {
+			return fmt.Errorf("-bbox: %w", err)
+		}
+		m.SetView(fitBoundsView(bounds, staticMapPadding, float32(width), float32(height), staticMapMinZoom, staticMapMaxZoom))
+	case len(frame) == 1:
+		m.SetView(frame[0], *zoom)
+	case len(frame) > 1:
+		m.SetView(fitBoundsView(latLngBounds(frame...), staticMapPadding, float32(width), float32(height), staticMapMinZoom, staticMapMaxZoom))
+	default:
+		return errors.New("nothing to show: give -center, -bbox, -marker or -geojson")
+	}
+
+	if err := m.ExportPNG(*out, width, height); err != nil {
+		return err
+	}
+	_, z := m.View()
+	fmt.Printf("Wrote %dx%d map at zoom %d to %s\n", width, height, z, *out)
+	return nil
+}
+
+func parseLatLng(v string) (LatLng, error) {
+	parts, err := parseFloats(v, 2)
+	if err != nil {
+		return LatLng{}, err
+	}
+	if parts[0] < -90 || parts[0] > 90 || parts[1] < -180 || parts[1] > 180 {
+		return LatLng{}, fmt.Errorf("'%s' is out of range", v)
+	}
+	return LatLng{Lat: parts[0], Lon: parts[1]}, nil
+}
+
+// parseBBox reads a GeoJSON-style bounding box: west, south, east, north.
+func parseBBox(v string) (LatLngBounds, error) {
+	parts, err := parseFloats(v, 4)
+	if err != nil {
+		return LatLngBounds{}, err
+	}
+	return LatLngBounds{
+		SouthWest: LatLng{Lat: parts[1], Lon: parts[0]},
+		NorthEast: LatLng{Lat: parts[3], Lon: parts[2]},
+	}, nil
+}
+
+func parseFloats(v string, n int) ([]float64, error) {
+	fields := strings.Split(v, ",")
+	if len(fields) != n {
+		return nil, fmt.Errorf("expected %d comma-separated numbers, got '%s'", n, v)
+	}
+	values := make([]float64, n)
+	for i, field := range fields {
+		f, err := strconv.ParseFloat(strings.TrimSpace(field), 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number '%s': %w", field, err)
+		}
+		values[i] = f
+	}
+	return values, nil
+}
+
+func parseImageSize(v string) (int, int, error) {
+	w, h, ok := strings.Cut(strings.ToLower(v), "x")
+	width, errW := strconv.Atoi(w)
+	height, errH := strconv.Atoi(h)
+	if !ok || errW != nil || errH != nil || width <= 0 || height <= 0 || width > exportMaxSize || height > exportMaxSize {
+		return 0, 0, fmt.Errorf("invalid size '%s', want WIDTHxHEIGHT up to %d", v, exportMaxSize)
+	}
+	return width, height, nil
+}
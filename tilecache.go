@@ -0,0 +1,128 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io/fs"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// The tile cache keeps each tile source apart:
+//
+//	<cache>/<provider>/<style>/<scale>/<z>/<x>/<y>.png
+//
+// Mapbox styles are stored as mapbox/<owner>/<style id>; other providers as
+// <host>/<hash of the URL template>. The VERSION file records the layout.
+// Caches without it hold the flat <z>/<x>/<y>.png layout of the default
+// style and are migrated by initTileCache.
+const (
+	tileCacheLayoutVersion = 2
+	tileCacheVersionFile   = "VERSION"
+	tileCacheScale         = 1
+	tileCacheExt           = ".png"
+)
+
+// getTileFilePath returns where the tile at coord of the source cached in
+// cacheDir is kept.
+func getTileFilePath(cacheDir string, coord TileCoord) string {
+	return filepath.Join(tileCachePath, cacheDir, strconv.Itoa(coord.Z), strconv.Itoa(coord.X), strconv.Itoa(coord.Y)+tileCacheExt)
+}
+
+// tileCacheDir is the style's directory in the tile cache.
+func (s MapStyle) tileCacheDir() string {
+	scale := fmt.Sprintf("%dx", tileCacheScale)
+	if s.URLTemplate == "" {
+		return filepath.Join("mapbox", s.Owner, s.StyleID, scale)
+	}
+	provider := "custom"
+	if u, err := url.Parse(s.URLTemplate); err == nil && u.Hostname() != "" {
+		provider = u.Hostname()
+	}
+	h := fnv.New64a()
+	h.Write([]byte(s.URLTemplate))
+	return filepath.Join(provider, fmt.Sprintf("%016x", h.Sum64()), scale)
+}
+
+// migrateTileCache moves the tiles of an older cache layout in root into
+// the current one and writes the version marker.
+func migrateTileCache(root string) error {
+	versionPath := filepath.Join(root, tileCacheVersionFile)
+	data, err := os.ReadFile(versionPath)
+	switch {
+	case err == nil:
+		version, err := strconv.Atoi(strings.TrimSpace(string(data)))
+		if err != nil {
+			return fmt.Errorf("invalid cache version in '%s': %w", versionPath, err)
+		}
+		if version > tileCacheLayoutVersion {
+			return fmt.Errorf("cache layout version %d is newer than supported version %d", version, tileCacheLayoutVersion)
+		}
+		if version == tileCacheLayoutVersion {
+			return nil
+		}
+	case !errors.Is(err, fs.ErrNotExist):
+		return fmt.Errorf("read '%s': %w", versionPath, err)
+	}
+
+	entries, err := os.ReadDir(root)
+	if err != nil {
+		return fmt.Errorf("read '%s': %w", root, err)
+	}
+	moved := 0
+	for _, entry := range entries {
+		name := entry.Name()
+		if _, err := strconv.Atoi(name); err != nil || !entry.IsDir() {
+			continue
+		}
+		// Flat zoom directories always held the default style.
+		dst := filepath.Join(root, mapboxDarkStyle.tileCacheDir(), name)
+		if err := moveTileDir(filepath.Join(root, name), dst); err != nil {
+			return fmt.Errorf("migrate '%s': %w", name, err)
+		}
+		moved++
+	}
+	if err := os.WriteFile(versionPath, []byte(strconv.Itoa(tileCacheLayoutVersion)+"\n"), 0644); err != nil {
+		return fmt.Errorf("write '%s': %w", versionPath, err)
+	}
+	if moved > 0 {
+		log.Printf("Migrated %d zoom levels of tile cache '%s' to layout version %d", moved, root, tileCacheLayoutVersion)
+	}
+	return nil
+}
+
+// moveTileDir moves the tiles under src to dst. Tiles already at dst win.
+func moveTileDir(src, dst string) error {
+	if _, err := os.Stat(dst); errors.Is(err, fs.ErrNotExist) {
+		if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+			return err
+		}
+		return os.Rename(src, dst)
+	}
+	err := filepath.WalkDir(src, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+		if _, err := os.Stat(target); err == nil {
+			return nil
+		}
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return err
+		}
+		return os.Rename(path, target)
+	})
+	if err != nil {
+		return err
+	}
+	return os.RemoveAll(src)
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+// writeTree creates files, given by slash-separated path, under root.
+func writeTree(t *testing.T, root string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		path := filepath.Join(root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// readTree returns the files under root by slash-separated path.
+func readTree(t *testing.T, root string) map[string]string {
+	t.Helper()
+	files := map[string]string{}
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		files[filepath.ToSlash(rel)] = string(data)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return files
+}
+
+func TestMigrateTileCache(t *testing.T) {
+	dark := filepath.ToSlash(mapboxDarkStyle.tileCacheDir())
+	version := strconv.Itoa(tileCacheLayoutVersion) + "\n"
+
+	root := t.TempDir()
+	writeTree(t, root, map[string]string{
+		"7/100/60.png":  "flat a",
+		"7/101/60.png":  "flat b",
+		"8/200/120.png": "flat c",
+		"notes.txt":     "kept",
+	})
+	if err := migrateTileCache(root); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		dark + "/7/100/60.png":  "flat a",
+		dark + "/7/101/60.png":  "flat b",
+		dark + "/8/200/120.png": "flat c",
+		"notes.txt":             "kept",
+		tileCacheVersionFile:    version,
+	}
+	if got := readTree(t, root); !reflect.DeepEqual(got, want) {
+		t.Errorf("after migration got %v, want %v", got, want)
+	}
+
+	// A second run finds the current version and changes nothing, even with
+	// a flat directory back in place.
+	writeTree(t, root, map[string]string{"9/1/1.png": "new flat"})
+	if err := migrateTileCache(root); err != nil {
+		t.Fatal(err)
+	}
+	want["9/1/1.png"] = "new flat"
+	if got := readTree(t, root); !reflect.DeepEqual(got, want) {
+		t.Errorf("after second run got %v, want %v", got, want)
+	}
+}
+
+func TestMigrateTileCacheKeepsExistingTiles(t *testing.T) {
+	dark := filepath.ToSlash(mapboxDarkStyle.tileCacheDir())
+	root := t.TempDir()
+	writeTree(t, root, map[string]string{
+		"7/100/60.png":         "flat a",
+		"7/101/60.png":         "flat b",
+		dark + "/7/100/60.png": "versioned a",
+	})
+	if err := migrateTileCache(root); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		dark + "/7/100/60.png": "versioned a",
+		dark + "/7/101/60.png": "flat b",
+		tileCacheVersionFile:   strconv.Itoa(tileCacheLayoutVersion) + "\n",
+	}
+	if got := readTree(t, root); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestMigrateTileCacheRefusesNewerVersion(t *testing.T) {
+	root := t.TempDir()
+	files := map[string]string{
+		"7/100/60.png":       "flat a",
+		tileCacheVersionFile: strconv.Itoa(tileCacheLayoutVersion+1) + "\n",
+	}
+	writeTree(t, root, files)
+	if err := migrateTileCache(root); err == nil {
+		t.Error("migrated a cache with a newer layout version")
+	}
+	if got := readTree(t, root); !reflect.DeepEqual(got, files) {
+		t.Errorf("cache changed to %v, want %v", got, files)
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"time"
+)
+
+// TileEventKind is a step in loading one tile.
+type TileEventKind int
+
+const (
+	// TileRequested: the tile was not in memory and is being loaded, from the
+	// disk cache if possible and otherwise from the network.
+	TileRequested TileEventKind = iota
+	// TileCacheHit: the tile was read from the disk cache and is in memory.
+	TileCacheHit
+	// TileLoaded: the download finished and the tile is cached.
+	TileLoaded
+	// TileNotFound: the provider has no tile at these coordinates; the tile is
+	// not requested again until retried.
+	TileNotFound
+	// TileFailed: the download failed; the tile is requested again after a
+	// backoff, or when retried.
+	TileFailed
+	// TileCancelled: no map needed the tile any more, so its load was
+	// abandoned; nothing was cached.
+	TileCancelled
+)
+
+var tileEventKindNames = [...]string{"requested", "cache hit", "loaded", "not found", "failed", "cancelled"}
+
+func (k TileEventKind) String() string {
+	if k < 0 || int(k) >= len(tileEventKindNames) {
+		return fmt.Sprintf("TileEventKind(%d)", int(k))
+	}
+	return tileEventKindNames[k]
+}
+
+// TileEvent reports progress on one tile of one tile source.
+type TileEvent struct {
+	Kind  TileEventKind
+	Coord TileCoord
+	// Source is the URL template of the tile source.
+	Source string
+	// Image is set for TileCacheHit and TileLoaded.
+	Image image.Image
+	// Err is a *TileError for TileNotFound, TileFailed and TileCancelled.
+	Err error
+	// Elapsed is the time since TileRequested, for every event but TileRequested.
+	Elapsed time.Duration
+}
+
+var (
+	ErrTileNotFound     = errors.New("tile not found")
+	ErrTileUnauthorized = errors.New("tile provider rejected the access token")
+	ErrTileTimeout      = errors.New("tile download timed out")
+	ErrTileCancelled    = errors.New("tile download cancelled")
+)
+
+// TileError is a failed tile download. Err is one of the ErrTile values or
+// the underlying request, HTTP or decoding error.
+type TileError struct {
+	Coord TileCoord
+	// Status is the HTTP status code, or 0 if there was no response.
+	Status int
+	Err    error
+}
+
+func (e *TileError) Error() string {
+	if e.Status != 0 {
+		return fmt.Sprintf("tile %d/%d/%d: HTTP %d: %v", e.Coord.Z, e.Coord.X, e.Coord.Y, e.Status, e.Err)
+	}
+	return fmt.Sprintf("tile %d/%d/%d: %v", e.Coord.Z, e.Coord.X, e.Coord.Y, e.Err)
+}
+
+func (e *TileError) Unwrap() error { return e.Err }
+
+// tileOutcome classifies how a download ended.
+func tileOutcome(err error) TileEventKind {
+	switch {
+	case err == nil:
+		return TileLoaded
+	case errors.Is(err, ErrTileNotFound):
+		return TileNotFound
+	case errors.Is(err, ErrTileCancelled):
+		return TileCancelled
+	default:
+		return TileFailed
+	}
+}
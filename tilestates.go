@@ -0,0 +1,212 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+	"time"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/canvas"
+	"fyne.io/fyne/v2/theme"
+)
+
+const (
+	tileFadeDuration    = 200 * time.Millisecond
+	placeholderGridStep = 32
+	errorHatchStep      = 16
+	retryIconSize       = 32
+)
+
+// tileDrawState is what a TileLayer last drew for a tile.
+type tileDrawState int
+
+const (
+	tileNotDrawn tileDrawState = iota
+	tileDrawnPlaceholder
+	tileDrawnError
+	tileDrawnImage
+)
+
+// tilePatterns are the loading and error tiles drawn in place of missing
+// tiles, for one set of theme colors.
+type tilePatterns struct {
+	colors  [3]color.NRGBA
+	loading *image.RGBA
+	failed  *image.RGBA
+}
+
+// currentTilePatterns returns p if it matches the current theme, or new patterns.
+func currentTilePatterns(p *tilePatterns) *tilePatterns {
+	colors := [3]color.NRGBA{
+		toNRGBA(theme.Color(theme.ColorNameInputBackground)),
+		toNRGBA(theme.Color(theme.ColorNameInputBorder)),
+		toNRGBA(theme.Color(theme.ColorNameError)),
+	}
+	if p != nil && p.colors == colors {
+		return p
+	}
+	fill, line, errColor := colors[0], colors[1], colors[2]
+
+	// Loading: a quiet grid in the input colors.
+	loading := image.NewRGBA(image.Rect(0, 0, mapTileSize, mapTileSize))
+	for y := 0; y < mapTileSize; y++ {
+		for x := 0; x < mapTileSize; x++ {
+			c := fill
+			if x%placeholderGridStep == 0 || y%placeholderGridStep == 0 {
+				c = line
+			}
+			loading.Set(x, y, c)
+		}
+	}
+
+	// Failed: diagonal hatching and a border in the error color.
+	hatch := blendNRGBA(fill, errColor, 0.25)
+	border := blendNRGBA(fill, errColor, 0.65)
+	failed := image.NewRGBA(loading.Bounds())
+	for y := 0; y < mapTileSize; y++ {
+		for x := 0; x < mapTileSize; x++ {
+			c := fill
+			switch {
+			case x < 2 || y < 2 || x >= mapTileSize-2 || y >= mapTileSize-2:
+				c = border
+			case (x+y)%errorHatchStep < 3:
+				c = hatch
+			}
+			failed.Set(x, y, c)
+		}
+	}
+	return &tilePatterns{colors: colors, loading: loading, failed: failed}
+}
+
+// blendNRGBA mixes a fraction t of over into base.
+func blendNRGBA(base, over color.NRGBA, t float64) color.NRGBA {
+	mix := func(a, b uint8) uint8 { return uint8(float64(a) + (float64(b)-float64(a))*t) }
+	return color.NRGBA{R: mix(base.R, over.R), G: mix(base.G, over.G), B: mix(base.B, over.B), A: mix(base.A, over.A)}
+}
+
+// drawTileState draws what state calls for into target, a part of tileRect.
+// fade is the opacity of a tile image fading in over its placeholder. Layers
+// without placeholders leave missing tiles transparent.
+func (l *TileLayer) drawTileState(target, tileRect image.Rectangle, state tileDrawState, imgData image.Image, fade float64) {
+	offset := target.Min.Sub(tileRect.Min)
+	if state == tileDrawnImage && fade >= 1 {
+		draw.Draw(l.composite, target, imgData, imgData.Bounds().Min.Add(offset), draw.Src)
+		return
+	}
+
+	var background image.Image = image.Transparent
+	switch {
+	case !l.placeholders:
+	case state == tileDrawnError:
+		background = l.patterns.failed
+	default:
+		background = l.patterns.loading
+	}
+	draw.Draw(l.composite, target, background, offset, draw.Src)
+	if state == tileDrawnImage {
+		mask := image.NewUniform(color.Alpha{A: uint8(255 * clampUnit(fade))})
+		draw.DrawMask(l.composite, target, imgData, imgData.Bounds().Min.Add(offset), mask, image.Point{}, draw.Over)
+	}
+}
+
+// fadeProgress returns how far the fade-in of a tile that arrived at start
+// has come, between 0 and 1.
+func fadeProgress(start time.Time) float64 {
+	return math.Min(1, float64(time.Since(start))/float64(tileFadeDuration))
+}
+
+// animateFades keeps the map redrawing while tiles are fading in, and stops
+// once they are all opaque.
+func (l *TileLayer) animateFades() {
+	if len(l.fading) == 0 {
+		if l.fadeAnimation != nil {
+			l.fadeAnimation.Stop()
+			l.fadeAnimation = nil
+		}
+		return
+	}
+	l.mu.RLock()
+	m := l.mapWidget
+	l.mu.RUnlock()
+	if l.fadeAnimation != nil || m == nil {
+		return
+	}
+	l.fadeAnimation = fyne.NewAnimation(tileFadeDuration, func(float32) { m.Refresh() })
+	l.fadeAnimation.RepeatCount = fyne.AnimationRepeatForever
+	l.fadeAnimation.Start()
+}
+
+// stopFades stops the fade animation of a layer that is no longer drawn, as
+// its refresh will not run to stop it. A later refresh starts it again.
+func (l *TileLayer) stopFades() {
+	if l.fadeAnimation != nil {
+		l.fadeAnimation.Stop()
+		l.fadeAnimation = nil
+	}
+}
+
+// =====================================================
+// Retry
+// =====================================================
+
+// tileRetryMark is the "tap to retry" sign drawn over an error tile.
+type tileRetryMark struct {
+	icon  *canvas.Image
+	label *canvas.Text
+}
+
+func newTileRetryMark() *tileRetryMark {
+	icon := canvas.NewImageFromResource(theme.NewErrorThemedResource(theme.ViewRefreshIcon()))
+	icon.Resize(fyne.NewSquareSize(retryIconSize))
+	label := canvas.NewText("Tap to retry", theme.Color(theme.ColorNameError))
+	label.TextSize = theme.CaptionTextSize()
+	return &tileRetryMark{icon: icon, label: label}
+}
+
+// place centers the mark in the visible part of a tile.
+func (mark *tileRetryMark) place(visible image.Rectangle) []fyne.CanvasObject {
+	mark.label.Color = theme.Color(theme.ColorNameError)
+	mark.label.Refresh()
+	labelSize := mark.label.MinSize()
+	midX := float32(visible.Min.X+visible.Max.X) / 2
+	midY := float32(visible.Min.Y+visible.Max.Y) / 2
+	mark.icon.Move(fyne.NewPos(midX-retryIconSize/2, midY-(retryIconSize+labelSize.Height)/2))
+	mark.label.Move(fyne.NewPos(midX-labelSize.Width/2, midY-(retryIconSize+labelSize.Height)/2+retryIconSize))
+	return []fyne.CanvasObject{mark.icon, mark.label}
+}
+
+// retryTileAt retries the failed tiles under pos in every tile layer that
+// draws error tiles. It reports whether there were any.
+func (m *TileMapWidget) retryTileAt(pos fyne.Position) bool {
+	view := m.currentView()
+	m.mu.RLock()
+	layers := make([]*TileLayer, 0, len(m.layers))
+	for _, layer := range m.layers {
+		if tiles, ok := layer.(*TileLayer); ok && tiles.placeholders && !m.hiddenLayers[tiles.id] {
+			layers = append(layers, tiles)
+		}
+	}
+	m.mu.RUnlock()
+
+	origin := view.pixelOrigin()
+	tileX := int(math.Floor(float64(int(pos.X)-origin.X) / mapTileSize))
+	tileY := int(math.Floor(float64(int(pos.Y)-origin.Y) / mapTileSize))
+	n := 1 << view.zoom
+	if tileY < 0 || tileY >= n {
+		return false
+	}
+	coord := TileCoord{Z: view.zoom, X: (tileX%n + n) % n, Y: tileY}
+
+	retried := false
+	for _, layer := range layers {
+		if layer.store.retry(coord) {
+			retried = true
+		}
+	}
+	if retried {
+		m.Refresh()
+	}
+	return retried
+}
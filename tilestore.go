@@ -0,0 +1,392 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/jpeg"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// fetchConcurrency caps the downloads a tile store runs at once.
+	fetchConcurrency = 6
+	// diskReadConcurrency caps the disk cache reads a tile store runs at once.
+	diskReadConcurrency = 4
+	// A failed tile is loaded again after tileRetryBackoff, doubling with each
+	// further failure up to tileRetryBackoffMax.
+	tileRetryBackoff    = 15 * time.Second
+	tileRetryBackoffMax = 5 * time.Minute
+)
+
+// tileStore caches the decoded tiles of one tile source and schedules their
+// downloads. Every layer drawing the same source shares one store, so maps
+// showing the same provider never fetch or hold a tile twice.
+type tileStore struct {
+	urlTemplate string
+	// cacheDir is the store's directory under tileCachePath, or empty when
+	// its tiles are not kept on disk.
+	cacheDir   string
+	fetchSlots chan struct{}
+	diskSlots  chan struct{}
+
+	mu             sync.RWMutex
+	imageDataCache map[TileCoord]image.Image
+	tileLoading    map[TileCoord]time.Time
+	tileFailures   map[TileCoord]tileFailure
+	subscribers    map[*TileMapWidget]int
+	// loadCtx is the context of running loads; cancelLoads ends them.
+	loadCtx     context.Context
+	cancelLoads context.CancelFunc
+
+	memoryHits atomic.Int64
+	diskHits   atomic.Int64
+	downloads  atomic.Int64
+}
+
+// tileLoad is one load of a tile, from TileRequested until finish.
+type tileLoad struct {
+	coord       TileCoord
+	requestedAt time.Time
+	ctx         context.Context
+}
+
+// tileFailure is why a tile last failed to load and when it may be loaded
+// again. Tiles the provider does not have are never reloaded by themselves.
+type tileFailure struct {
+	err      error
+	attempts int
+	retryAt  time.Time
+}
+
+func (f tileFailure) due(now time.Time) bool {
+	return !f.retryAt.IsZero() && !now.Before(f.retryAt)
+}
+
+// tileStats counts how a store's tile lookups were served.
+type tileStats struct {
+	memoryHits, diskHits, downloads int64
+}
+
+var (
+	tileStoresMu sync.Mutex
+	tileStores   = make(map[string]*tileStore)
+)
+
+// sharedTileStore returns the store for urlTemplate, creating it on first use.
+// cacheDir only applies to a newly created store.
+func sharedTileStore(urlTemplate, cacheDir string) *tileStore {
+	tileStoresMu.Lock()
+	defer tileStoresMu.Unlock()
+	if s, ok := tileStores[urlTemplate]; ok {
+		return s
+	}
+	s := &tileStore{
+		urlTemplate:    urlTemplate,
+		cacheDir:       cacheDir,
+		fetchSlots:     make(chan struct{}, fetchConcurrency),
+		diskSlots:      make(chan struct{}, diskReadConcurrency),
+		imageDataCache: make(map[TileCoord]image.Image),
+		tileLoading:    make(map[TileCoord]time.Time),
+		tileFailures:   make(map[TileCoord]tileFailure),
+		subscribers:    make(map[*TileMapWidget]int),
+	}
+	s.loadCtx, s.cancelLoads = context.WithCancel(context.Background())
+	tileStores[urlTemplate] = s
+	return s
+}
+
+// subscribe makes m receive this store's tile events.
+func (s *tileStore) subscribe(m *TileMapWidget) {
+	s.mu.Lock()
+	s.subscribers[m]++
+	s.mu.Unlock()
+}
+
+// unsubscribe stops m receiving events and returns how many maps remain.
+// Once no map is left, running loads are cancelled.
+func (s *tileStore) unsubscribe(m *TileMapWidget) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.subscribers[m] <= 1 {
+		delete(s.subscribers, m)
+	} else {
+		s.subscribers[m]--
+	}
+	if len(s.subscribers) == 0 {
+		s.cancelLoadsLocked()
+	}
+	return len(s.subscribers)
+}
+
+// release drops the store's tiles and failures from memory and cancels
+// running loads.
+func (s *tileStore) release() {
+	s.mu.Lock()
+	clear(s.imageDataCache)
+	clear(s.tileFailures)
+	s.cancelLoadsLocked()
+	s.mu.Unlock()
+}
+
+// cancelLoadsLocked cancels the running loads; they finish as TileCancelled.
+func (s *tileStore) cancelLoadsLocked() {
+	if len(s.tileLoading) == 0 {
+		return
+	}
+	s.cancelLoads()
+	s.loadCtx, s.cancelLoads = context.WithCancel(context.Background())
+	clear(s.tileLoading)
+}
+
+func (s *tileStore) tileURL(coord TileCoord) string {
+	return strings.NewReplacer(
+		"{z}", strconv.Itoa(coord.Z),
+		"{x}", strconv.Itoa(coord.X),
+		"{y}", strconv.Itoa(coord.Y),
+		"{size}", strconv.Itoa(mapTileSize),
+	).Replace(s.urlTemplate)
+}
+
+// tile returns the in-memory image for coord. A missing tile is loaded in
+// the background, from the disk cache if possible and otherwise from the
+// network; the subscribed widgets receive a TileEvent for every step. tile
+// never touches the disk or network itself, so it is safe to call while
+// rendering. A tile whose load failed is not requested again until retry or
+// its backoff has passed; a tile the provider does not have only on retry.
+func (s *tileStore) tile(coord TileCoord) (image.Image, bool) {
+	s.mu.Lock()
+	if imgData, found := s.imageDataCache[coord]; found {
+		s.mu.Unlock()
+		s.memoryHits.Add(1)
+		return imgData, true
+	}
+	if failure, failed := s.tileFailures[coord]; failed && !failure.due(time.Now()) {
+		s.mu.Unlock()
+		return nil, false
+	}
+	_, loading := s.tileLoading[coord]
+	load := tileLoad{coord: coord, requestedAt: time.Now(), ctx: s.loadCtx}
+	if !loading {
+		s.tileLoading[coord] = load.requestedAt
+	}
+	s.mu.Unlock()
+
+	if !loading {
+		s.emit(TileEvent{Kind: TileRequested, Coord: coord})
+		go s.load(load)
+	}
+	return nil, false
+}
+
+// load reads the tile from the disk cache, falling back to a download. Only
+// one load per tile runs at a time: tile marks it pending until finish.
+func (s *tileStore) load(load tileLoad) {
+	if s.cacheDir != "" && tileCachePath != "" {
+		tileFilePath := getTileFilePath(s.cacheDir, load.coord)
+		s.diskSlots <- struct{}{}
+		cachedImg, err := readTileFromCache(tileFilePath)
+		<-s.diskSlots
+		if err == nil && cachedImg != nil {
+			s.diskHits.Add(1)
+			s.finish(load, TileCacheHit, cachedImg, nil)
+			return
+		} else if err != nil && !os.IsNotExist(err) {
+			log.Printf("Warning: Error reading tile cache file %s: %v", tileFilePath, err)
+		}
+	}
+	if load.ctx.Err() != nil {
+		s.finish(load, TileCancelled, nil, &TileError{Coord: load.coord, Err: ErrTileCancelled})
+		return
+	}
+	s.downloads.Add(1)
+	s.fetchTileDataAsync(load)
+}
+
+// failure returns why the last load of coord failed, or nil. A failed tile
+// keeps its failure while it is loaded again after its backoff.
+func (s *tileStore) failure(coord TileCoord) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.tileFailures[coord].err
+}
+
+// retry forgets the failure of coord so that the next lookup loads it again.
+// It reports whether coord had failed.
+func (s *tileStore) retry(coord TileCoord) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, failed := s.tileFailures[coord]
+	delete(s.tileFailures, coord)
+	return failed
+}
+
+func (s *tileStore) stats() tileStats {
+	return tileStats{memoryHits: s.memoryHits.Load(), diskHits: s.diskHits.Load(), downloads: s.downloads.Load()}
+}
+
+// status reports whether coord is in memory and whether, and since when, it
+// is being loaded. It never schedules a load.
+func (s *tileStore) status(coord TileCoord) (cached bool, fetchingSince time.Time, fetching bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	_, cached = s.imageDataCache[coord]
+	fetchingSince, fetching = s.tileLoading[coord]
+	return cached, fetchingSince, fetching
+}
+
+// refreshSubscribers schedules a redraw of every subscribed widget.
+func (s *tileStore) refreshSubscribers() {
+	s.mu.RLock()
+	subscribers := make([]*TileMapWidget, 0, len(s.subscribers))
+	for m := range s.subscribers {
+		subscribers = append(subscribers, m)
+	}
+	s.mu.RUnlock()
+
+	for _, m := range subscribers {
+		m.queueRefresh()
+	}
+}
+
+// emit delivers ev to every subscribed widget, outside the store lock.
+func (s *tileStore) emit(ev TileEvent) {
+	ev.Source = s.urlTemplate
+	s.mu.RLock()
+	subscribers := make([]*TileMapWidget, 0, len(s.subscribers))
+	for m := range s.subscribers {
+		subscribers = append(subscribers, m)
+	}
+	s.mu.RUnlock()
+
+	for _, m := range subscribers {
+		m.handleTileEvent(ev)
+	}
+}
+
+// finish records the outcome of a load, caching the image on success. A load
+// that was cancelled in the meantime is reported as TileCancelled.
+func (s *tileStore) finish(load tileLoad, kind TileEventKind, imgData image.Image, err error) {
+	coord := load.coord
+	s.mu.Lock()
+	requestedAt, loading := s.tileLoading[coord]
+	pending := loading && requestedAt.Equal(load.requestedAt)
+	if pending {
+		delete(s.tileLoading, coord)
+	}
+	if !pending && kind != TileCancelled {
+		kind, imgData, err = TileCancelled, nil, &TileError{Coord: coord, Err: ErrTileCancelled}
+	}
+	var retryIn time.Duration
+	switch {
+	case !pending:
+	case kind == TileCacheHit || kind == TileLoaded:
+		s.imageDataCache[coord] = imgData
+		delete(s.tileFailures, coord)
+	case kind == TileNotFound:
+		s.tileFailures[coord] = tileFailure{err: err}
+	case kind == TileFailed:
+		failure := tileFailure{err: err, attempts: s.tileFailures[coord].attempts + 1}
+		retryIn = min(tileRetryBackoff<<min(failure.attempts-1, 5), tileRetryBackoffMax)
+		failure.retryAt = time.Now().Add(retryIn)
+		s.tileFailures[coord] = failure
+	}
+	s.mu.Unlock()
+
+	if retryIn > 0 {
+		// Redraw the maps once the tile is due, so that they request it again.
+		time.AfterFunc(retryIn, s.refreshSubscribers)
+	}
+
+	ev := TileEvent{Kind: kind, Coord: coord, Err: err, Elapsed: time.Since(load.requestedAt)}
+	if err == nil {
+		ev.Image = imgData
+	}
+	s.emit(ev)
+}
+
+func (s *tileStore) fetchTileDataAsync(load tileLoad) {
+	coord := load.coord
+	s.fetchSlots <- struct{}{}
+	defer func() { <-s.fetchSlots }()
+	defer func() {
+		if rec := recover(); rec != nil {
+			log.Printf("Panic fetch %v: %v", coord, rec)
+			s.finish(load, TileFailed, nil, &TileError{Coord: coord, Err: fmt.Errorf("panic: %v", rec)})
+		}
+	}()
+
+	imgData, err := s.download(load.ctx, coord)
+	if err == nil && s.cacheDir != "" && tileCachePath != "" {
+		tileFilePath := getTileFilePath(s.cacheDir, coord)
+		if err := writeTileToCache(tileFilePath, imgData); err != nil {
+			log.Printf("Warning: Failed to write tile %v to cache '%s': %v", coord, tileFilePath, err)
+		}
+	}
+	s.finish(load, tileOutcome(err), imgData, err)
+}
+
+// download fetches and decodes one tile. Errors are *TileError, and never
+// contain the tile URL, which may hold an access token.
+func (s *tileStore) download(ctx context.Context, coord TileCoord) (image.Image, error) {
+	ctx, cancel := context.WithTimeout(ctx, fetchTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, "GET", s.tileURL(coord), nil)
+	if err != nil {
+		return nil, &TileError{Coord: coord, Err: withoutURL(err)}
+	}
+	req.Header.Set("User-Agent", yourUserAgent)
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		switch {
+		case errors.Is(ctx.Err(), context.Canceled):
+			err = ErrTileCancelled
+		case ctx.Err() == context.DeadlineExceeded || os.IsTimeout(err):
+			err = ErrTileTimeout
+		default:
+			err = withoutURL(err)
+		}
+		return nil, &TileError{Coord: coord, Err: err}
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return nil, &TileError{Coord: coord, Status: resp.StatusCode, Err: ErrTileNotFound}
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return nil, &TileError{Coord: coord, Status: resp.StatusCode, Err: ErrTileUnauthorized}
+	default:
+		return nil, &TileError{Coord: coord, Status: resp.StatusCode, Err: fmt.Errorf("unexpected status %s", resp.Status)}
+	}
+
+	imgData, _, err := image.Decode(resp.Body)
+	if err != nil && errors.Is(ctx.Err(), context.Canceled) {
+		return nil, &TileError{Coord: coord, Err: ErrTileCancelled}
+	} else if err != nil {
+		return nil, &TileError{Coord: coord, Err: fmt.Errorf("image decode fail: %w", err)}
+	}
+	if imgData.Bounds().Dx() <= 0 || imgData.Bounds().Dy() <= 0 {
+		return nil, &TileError{Coord: coord, Err: errors.New("image has zero dimensions")}
+	}
+	return imgData, nil
+}
+
+// withoutURL strips the request URL from a *url.Error.
+func withoutURL(err error) error {
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		return urlErr.Err
+	}
+	return err
+}